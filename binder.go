@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+
+const MaxRequestBodySize int64 = 1 << 20
+
+
+//bindParams fills params struct from the query string of the request
+//and then from request body that can be json or html form
+//body values override query string values
+//fields are matched by names from json tags
+//so GET /v1/history/get_actions?account_name=eosio&pos=-1 is the same
+//as POST with {"account_name": "eosio", "pos": -1} body
+func bindParams(r *http.Request, params interface{}) error {
+	err := bindValues(r.URL.Query(), params)
+	if err != nil {
+		return err
+	}
+	if r.Body == nil {
+		return nil
+	}
+	defer r.Body.Close()
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		r.Body = http.MaxBytesReader(nil, r.Body, MaxRequestBodySize)
+		err = r.ParseMultipartForm(MaxRequestBodySize)
+		if err != nil && err != http.ErrNotMultipart {
+			return newBadParamsError("Invalid form body.")
+		}
+		return bindValues(r.PostForm, params)
+	default:
+		bytes, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, MaxRequestBodySize))
+		if err != nil {
+			return newBadParamsError("Failed to read request body.")
+		}
+		if len(strings.TrimSpace(string(bytes))) == 0 {
+			return nil
+		}
+		err = json.Unmarshal(bytes, params)
+		if err != nil {
+			return newBadParamsError("Invalid arguments.")
+		}
+	}
+	return nil
+}
+
+
+//bindValues sets fields of params struct from url values
+func bindValues(values url.Values, params interface{}) error {
+	v := new(validator)
+	value := reflect.ValueOf(params).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		raw, ok := values[name]
+		if len(name) == 0 || name == "-" || !ok || len(raw) == 0 {
+			continue
+		}
+		field := value.Field(i)
+		if field.Kind() == reflect.Ptr {
+			ptr := reflect.New(field.Type().Elem())
+			if err := setValue(ptr.Elem(), raw[0]); err != nil {
+				v.fail(name, err.Error())
+				continue
+			}
+			field.Set(ptr)
+		} else if err := setValue(field, raw[0]); err != nil {
+			v.fail(name, err.Error())
+		}
+	}
+	return v.err()
+}
+
+
+func setValue(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return errors.New("must be a non-negative integer")
+		}
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		//?extended is the same as ?extended=true
+		if len(raw) == 0 {
+			field.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		field.SetBool(b)
+	default:
+		return errors.New("can't be set from query string")
+	}
+	return nil
+}
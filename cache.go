@@ -0,0 +1,346 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+
+const DefaultCacheMaxEntries    int = 10000
+const DefaultCacheMaxAgeSeconds int = 86400
+const DiskCacheFileExt       string = ".json"
+//version of cached bodies in cache keys, files of older versions
+//left in the disk cache dir are never requested and are evicted first
+const CacheFormatVersion     string = "2"
+
+
+//lru keeps keys in order of use and evicts the least recently used ones
+//when number of items or their total size exceeds the limits
+type lru struct {
+	MaxEntries int
+	MaxBytes   int64
+	Bytes      int64
+	Items      map[string]*list.Element
+	Order      *list.List
+	//called for every evicted key
+	OnEvict    func(key string)
+}
+
+type lruItem struct {
+	Key   string
+	Value interface{}
+	Size  int64
+}
+
+func newLru(maxEntries int, maxBytes int64, onEvict func(key string)) *lru {
+	return &lru { MaxEntries: maxEntries, MaxBytes: maxBytes,
+		Items: make(map[string]*list.Element), Order: list.New(), OnEvict: onEvict }
+}
+
+func (c *lru) get(key string) (interface{}, bool) {
+	element, ok := c.Items[key]
+	if !ok {
+		return nil, false
+	}
+	c.Order.MoveToFront(element)
+	return element.Value.(*lruItem).Value, true
+}
+
+func (c *lru) add(key string, value interface{}, size int64) {
+	if element, ok := c.Items[key]; ok {
+		item := element.Value.(*lruItem)
+		c.Bytes += size - item.Size
+		item.Value = value
+		item.Size = size
+		c.Order.MoveToFront(element)
+	} else {
+		c.Items[key] = c.Order.PushFront(&lruItem { Key: key, Value: value, Size: size })
+		c.Bytes += size
+	}
+	for c.Order.Len() > 0 && ((c.MaxEntries > 0 && c.Order.Len() > c.MaxEntries) ||
+		(c.MaxBytes > 0 && c.Bytes > c.MaxBytes)) {
+		c.remove(c.Order.Back().Value.(*lruItem).Key)
+	}
+}
+
+func (c *lru) remove(key string) {
+	element, ok := c.Items[key]
+	if !ok {
+		return
+	}
+	item := element.Value.(*lruItem)
+	c.Order.Remove(element)
+	delete(c.Items, key)
+	c.Bytes -= item.Size
+	if c.OnEvict != nil {
+		c.OnEvict(key)
+	}
+}
+
+
+//cacheEntry is encoded response without last_irreversible_block with its ETag
+//ETag is weak because responses with different LIB have the same entry
+type cacheEntry struct {
+	Body []byte
+	ETag string
+}
+
+func newCacheEntry(body []byte) *cacheEntry {
+	sum := sha256.Sum256(body)
+	return &cacheEntry { Body: body, ETag: "W/\"" + hex.EncodeToString(sum[:16]) + "\"" }
+}
+
+
+//responseCache keeps encoded responses in memory
+//and optionally in files of the disk tier
+//responses that are evicted from memory stay on disk
+//and are brought back to memory when requested again
+type responseCache struct {
+	Lock   sync.Mutex
+	Memory *lru
+	//index of files in Dir, nil if disk tier is disabled
+	Disk   *lru
+	Dir    string
+}
+
+//newResponseCache creates cache for maxEntries responses in memory
+//and for up to maxMb megabytes of responses in dir
+//files left in dir by previous runs are reused
+func newResponseCache(maxEntries int, dir string, maxMb int64) *responseCache {
+	c := &responseCache { Memory: newLru(maxEntries, 0, nil) }
+	if len(dir) == 0 || maxMb <= 0 {
+		return c
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Printf("Disk cache is disabled: %s\n", err)
+		return c
+	}
+	c.Dir = dir
+	c.Disk = newLru(0, maxMb << 20, func(name string) {
+		os.Remove(filepath.Join(dir, name))
+	})
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return c
+	}
+	//the oldest files are evicted first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, file := range files {
+		if file.Mode().IsRegular() && strings.HasSuffix(file.Name(), DiskCacheFileExt) {
+			c.Disk.add(file.Name(), nil, file.Size())
+		}
+	}
+	return c
+}
+
+func diskCacheFileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + DiskCacheFileExt
+}
+
+//get returns cached response for the key from memory or from disk
+func (c *responseCache) get(key string) (*cacheEntry, bool) {
+	c.Lock.Lock()
+	if value, ok := c.Memory.get(key); ok {
+		c.Lock.Unlock()
+		observeCache("memory", true)
+		return value.(*cacheEntry), true
+	}
+	observeCache("memory", false)
+	if c.Disk == nil {
+		c.Lock.Unlock()
+		return nil, false
+	}
+	name := diskCacheFileName(key)
+	_, ok := c.Disk.get(name)
+	c.Lock.Unlock()
+	if !ok {
+		observeCache("disk", false)
+		return nil, false
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(c.Dir, name))
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+	if err != nil {
+		c.Disk.remove(name)
+		observeCache("disk", false)
+		return nil, false
+	}
+	observeCache("disk", true)
+	entry := newCacheEntry(body)
+	c.Memory.add(key, entry, int64(len(body)))
+	return entry, true
+}
+
+//put stores response in memory and on disk
+func (c *responseCache) put(key string, entry *cacheEntry) {
+	c.Lock.Lock()
+	c.Memory.add(key, entry, int64(len(entry.Body)))
+	c.Lock.Unlock()
+	if c.Disk == nil {
+		return
+	}
+	//file is renamed after it is written so that readers never see a part of it
+	name := diskCacheFileName(key)
+	tmp, err := ioutil.TempFile(c.Dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(entry.Body)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.Dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	c.Lock.Lock()
+	c.Disk.add(name, nil, int64(len(entry.Body)))
+	c.Lock.Unlock()
+}
+
+
+//cacheKey returns key of the request to the endpoint
+//params are normalized by encoding them to json
+//so the same request sent as query string or json body has the same key
+func cacheKey(endpoint string, params interface{}) string {
+	b, _ := json.Marshal(params)
+	return CacheFormatVersion + ":" + endpoint + ":" + string(b)
+}
+
+//nodeLastIrreversibleBlock returns LIB of the seed node
+//that is sent with every cacheable response, nil if the node is not available
+func nodeLastIrreversibleBlock(ctx context.Context, seedNode string) json.RawMessage {
+	info, err := getInfo(ctx, seedNode)
+	if err != nil {
+		return nil
+	}
+	return info.LastIrreversibleBlockNum
+}
+
+//withLastIrreversibleBlock adds last_irreversible_block to encoded json object
+//LIB is not a part of cached bodies because it changes
+//while the rest of irreversible response does not
+func withLastIrreversibleBlock(body []byte, lib json.RawMessage) []byte {
+	if len(lib) == 0 {
+		lib = json.RawMessage("null")
+	}
+	b := make([]byte, 0, len(body) + len(lib) + 32)
+	b = append(b, body[:len(body)-1]...)
+	if len(body) > 2 {
+		b = append(b, ',')
+	}
+	b = append(b, `"last_irreversible_block":`...)
+	b = append(b, lib...)
+	return append(b, '}')
+}
+
+//lastIrreversibleBlock returns LIB from the last fetched chain info
+func (s *Server) lastIrreversibleBlock() (uint64, bool) {
+	info, _, _ := s.getChainInfo()
+	if info == nil {
+		return 0, false
+	}
+	var lib uint64
+	if json.Unmarshal(info.LastIrreversibleBlockNum, &lib) != nil {
+		return 0, false
+	}
+	return lib, true
+}
+
+//isIrreversible checks that all block numbers are at or below
+//the last irreversible block known to the server
+func (s *Server) isIrreversible(blockNums ...json.RawMessage) bool {
+	lib, ok := s.lastIrreversibleBlock()
+	if !ok {
+		return false
+	}
+	for _, raw := range blockNums {
+		var blockNum uint64
+		if json.Unmarshal(raw, &blockNum) != nil || blockNum > lib {
+			return false
+		}
+	}
+	return true
+}
+
+
+//writeCached responds with cached response for the key and current lib
+//returns false if there is no such response
+func writeCached(w http.ResponseWriter, r *http.Request, st *Settings, key string, lib json.RawMessage) bool {
+	if st.Cache == nil {
+		return false
+	}
+	entry, ok := st.Cache.get(key)
+	if !ok {
+		return false
+	}
+	writeCacheEntry(w, r, st, entry, lib)
+	return true
+}
+
+//writeCacheableResult encodes result and sends it as a response with lib
+//results with irreversible data are cached without lib and sent with ETag
+//and Cache-Control headers that allow caching by clients and proxies,
+//other results are sent with Cache-Control: no-cache
+func writeCacheableResult(w http.ResponseWriter, r *http.Request, st *Settings, key string, result interface{}, lib json.RawMessage, irreversible bool) {
+	b, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, r, newInternalError(err))
+		return
+	}
+	if !irreversible {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(withLastIrreversibleBlock(b, lib))
+		return
+	}
+	entry := newCacheEntry(b)
+	if st.Cache != nil {
+		st.Cache.put(key, entry)
+	}
+	writeCacheEntry(w, r, st, entry, lib)
+}
+
+//writeCacheEntry sends cached response with lib or 304 status
+//if client already has it according to If-None-Match header
+//response is not immutable because lib in it grows
+func writeCacheEntry(w http.ResponseWriter, r *http.Request, st *Settings, entry *cacheEntry, lib json.RawMessage) {
+	w.Header().Set("ETag", entry.ETag)
+	w.Header().Set("Cache-Control", "public, max-age=" + strconv.Itoa(st.CacheMaxAge))
+	if etagMatches(r.Header.Get("If-None-Match"), entry.ETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(withLastIrreversibleBlock(entry.Body, lib))
+}
+
+//etagMatches checks If-None-Match header value against ETag
+//weak comparison is used as required for If-None-Match
+func etagMatches(header string, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" || strings.TrimPrefix(value, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
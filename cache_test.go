@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+
+//cached response gets current LIB on every hit and keeps its ETag
+func TestCachedResponseLastIrreversibleBlock(t *testing.T) {
+	st := &Settings { Cache: newResponseCache(10, "", 0), CacheMaxAge: 60 }
+	result := GetTransactionResult { Id: "abc", BlockNum: json.RawMessage("5") }
+	key := cacheKey("get_transaction", GetTransactionParams { Id: "abc" })
+
+	first := httptest.NewRecorder()
+	writeCacheableResult(first, httptest.NewRequest(http.MethodGet, "/", nil), st, key, result, json.RawMessage("10"), true)
+	second := httptest.NewRecorder()
+	if !writeCached(second, httptest.NewRequest(http.MethodGet, "/", nil), st, key, json.RawMessage("20")) {
+		t.Fatal("response is not cached")
+	}
+	for _, test := range []struct {
+		resp *httptest.ResponseRecorder
+		lib  string
+	}{ { first, "10" }, { second, "20" } } {
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(test.resp.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid body %s: %s", test.resp.Body.String(), err)
+		}
+		if string(body["last_irreversible_block"]) != test.lib || string(body["id"]) != `"abc"` {
+			t.Errorf("got body %s, want last_irreversible_block %s", test.resp.Body.String(), test.lib)
+		}
+		if cc := test.resp.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+			t.Errorf("got Cache-Control %q", cc)
+		}
+	}
+	etag := first.Header().Get("ETag")
+	if len(etag) == 0 || second.Header().Get("ETag") != etag {
+		t.Fatalf("got ETags %q and %q, want the same", etag, second.Header().Get("ETag"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	notModified := httptest.NewRecorder()
+	writeCached(notModified, req, st, key, json.RawMessage("30"))
+	if notModified.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want 304", notModified.Code)
+	}
+}
+
+func TestWithLastIrreversibleBlock(t *testing.T) {
+	tests := []struct {
+		body string
+		lib  json.RawMessage
+		want string
+	}{
+		{ `{"id":"abc"}`, json.RawMessage("7"), `{"id":"abc","last_irreversible_block":7}` },
+		{ `{"id":"abc"}`, nil, `{"id":"abc","last_irreversible_block":null}` },
+		{ `{}`, json.RawMessage("7"), `{"last_irreversible_block":7}` },
+	}
+	for _, test := range tests {
+		if got := string(withLastIrreversibleBlock([]byte(test.body), test.lib)); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
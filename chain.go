@@ -1,38 +1,70 @@
 package main
 
 import (
+	"context"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"bytes"
 	"encoding/json"
 	"errors"
+	"sync"
 )
 
 
+//number of distinct accounts whose weights are taken from the node in one response
+const MaxWeightedAccounts      int = 100
+//number of get_account requests sent to the node at the same time
+const ChainAccountsConcurrency int = 4
+
+
+//sends request to node chain api bound to ctx
+//body is sent with POST method, nil body with GET method
+func nodeRequest(ctx context.Context, url string, body io.Reader) (*http.Response, error) {
+	method := http.MethodGet
+	if body != nil {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return nodeHttpClient.Do(req)
+}
+
 //returns info from node chain api
-func getInfo(seedNode string) (*ChainGetInfoResult, error) {
+func getInfo(ctx context.Context, seedNode string) (*ChainGetInfoResult, error) {
 	if len(seedNode) > 0 && seedNode[len(seedNode)-1] != '/' {
 		seedNode = seedNode + "/"
 	}
-	resp, err := http.Get(seedNode + "v1/chain/get_info")
+	resp, err := nodeRequest(ctx, seedNode + "v1/chain/get_info", nil)
 	if err != nil {
-		return nil, err
+		return nil, newNodeError(err)
 	}
 	bytes, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
-		return nil, err
+		return nil, newNodeError(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newNodeError(errors.New("Failed to get info from node"))
 	}
 	result := new(ChainGetInfoResult)
 	err = json.Unmarshal(bytes, &result)
-	return result, err
+	if err != nil {
+		return nil, newNodeError(err)
+	}
+	return result, nil
 }
 
 //takes blockNum and transactionId as arguments
 //retrieves block from node chain api
 //searches requested transaction in retrieved block
 //returns the trx->trx field contents in the correct format
-func getTransactionFromBlock(seedNode string, blockNum json.RawMessage, txId string) (json.RawMessage, error) {
+func getTransactionFromBlock(ctx context.Context, seedNode string, blockNum json.RawMessage, txId string) (json.RawMessage, error) {
 	if len(seedNode) > 0 && seedNode[len(seedNode)-1] != '/' {
 		seedNode = seedNode + "/"
 	}
@@ -40,19 +72,19 @@ func getTransactionFromBlock(seedNode string, blockNum json.RawMessage, txId str
 	u := GetBlockParams { BlockNum: blockNum }
 	b := new(bytes.Buffer)
 	json.NewEncoder(b).Encode(u)
-	resp, err := http.Post(seedNode + "v1/chain/get_block", "application/json", b)
+	resp, err := nodeRequest(ctx, seedNode + "v1/chain/get_block", b)
 	if err != nil {
-		return result, err
+		return result, newNodeError(err)
 	}
 	bytes, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
-		return result, err
+		return result, newNodeError(err)
 	}
 	var getBlockResult ChainGetBlockResult
 	err = json.Unmarshal(bytes, &getBlockResult)
 	if err != nil {
-		return result, err
+		return result, newNodeError(err)
 	}
 	for _, trx := range getBlockResult.Transactions {
 		var tmp interface{}
@@ -80,5 +112,133 @@ func getTransactionFromBlock(seedNode string, blockNum json.RawMessage, txId str
 			return result, err
 		}
 	}
-	return result, errors.New("Transaction not found")
-}
\ No newline at end of file
+	return result, newNotFoundError("Transaction not found in block")
+}
+
+//returns account info from node chain api
+func getAccountFromChain(ctx context.Context, seedNode string, accountName string) (*ChainGetAccountResult, error) {
+	if len(seedNode) > 0 && seedNode[len(seedNode)-1] != '/' {
+		seedNode = seedNode + "/"
+	}
+	u := ChainGetAccountParams { AccountName: accountName }
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(u)
+	resp, err := nodeRequest(ctx, seedNode + "v1/chain/get_account", b)
+	if err != nil {
+		return nil, newNodeError(err)
+	}
+	bytes, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, newNodeError(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newNodeError(errors.New("Failed to get account from node"))
+	}
+	result := new(ChainGetAccountResult)
+	err = json.Unmarshal(bytes, &result)
+	if err != nil {
+		return nil, newNodeError(err)
+	}
+	return result, nil
+}
+
+//getChainAccounts gets current on-chain authorities of the first MaxWeightedAccounts
+//distinct accounts of permissions with at most ChainAccountsConcurrency requests at a time
+//unavailable and skipped accounts are missing from the result
+func getChainAccounts(ctx context.Context, seedNode string, permissions []PermissionAccount) map[string]*ChainGetAccountResult {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, permission := range permissions {
+		if !seen[permission.Account] && len(names) < MaxWeightedAccounts {
+			seen[permission.Account] = true
+			names = append(names, permission.Account)
+		}
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	chainAccounts := make(map[string]*ChainGetAccountResult)
+	jobs := make(chan string)
+	for w := 0; w < ChainAccountsConcurrency && w < len(names); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				chainAccount, err := getAccountFromChain(ctx, seedNode, name)
+				if err != nil {
+					continue
+				}
+				lock.Lock()
+				chainAccounts[name] = chainAccount
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+	return chainAccounts
+}
+
+//takes permissions found in ES and fills their weight and threshold
+//from the current on-chain authority of every account
+//weights function returns copies of the permission with the weight of the requested
+//key or controller, or nil if the authority doesn't have it
+//permissions of unavailable accounts are left with null weight and threshold
+func fillPermissionWeights(ctx context.Context, seedNode string, permissions []PermissionAccount,
+	weights func(permission *ChainPermission, account PermissionAccount) []PermissionAccount) []PermissionAccount {
+	chainAccounts := getChainAccounts(ctx, seedNode, permissions)
+	result := make([]PermissionAccount, 0, len(permissions))
+	for _, account := range permissions {
+		filled := false
+		if chainAccount := chainAccounts[account.Account]; chainAccount != nil {
+			for j, _ := range chainAccount.Permissions {
+				permission := &chainAccount.Permissions[j]
+				if permission.PermName != account.Permission {
+					continue
+				}
+				account.Threshold = permission.RequiredAuth.Threshold
+				if weighted := weights(permission, account); len(weighted) > 0 {
+					result = append(result, weighted...)
+					filled = true
+				}
+			}
+		}
+		if !filled {
+			result = append(result, account)
+		}
+	}
+	return result
+}
+
+//fills weights of the public key in permissions returned by get_key_accounts
+func fillKeyWeights(ctx context.Context, seedNode string, publicKey string, permissions []PermissionAccount) []PermissionAccount {
+	return fillPermissionWeights(ctx, seedNode, permissions, func(permission *ChainPermission, account PermissionAccount) []PermissionAccount {
+		for _, key := range permission.RequiredAuth.Keys {
+			if samePublicKey(key.Key, publicKey) {
+				account.Weight = key.Weight
+				return []PermissionAccount { account }
+			}
+		}
+		return nil
+	})
+}
+
+//fills weights of the controlling account in permissions returned by get_controlled_accounts
+//permission can be controlled by several permissions of the same account
+//with different weights, every one of them is returned with its controlling_permission
+func fillControllerWeights(ctx context.Context, seedNode string, controller string, permissions []PermissionAccount) []PermissionAccount {
+	return fillPermissionWeights(ctx, seedNode, permissions, func(permission *ChainPermission, account PermissionAccount) []PermissionAccount {
+		weighted := make([]PermissionAccount, 0)
+		for _, auth := range permission.RequiredAuth.Accounts {
+			if auth.Permission.Actor == controller {
+				account.ControllingPermission = auth.Permission.Permission
+				account.Weight = auth.Weight
+				weighted = append(weighted, account)
+			}
+		}
+		return weighted
+	})
+}
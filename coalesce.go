@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+
+//flightGroup runs a function once for concurrent calls with the same key
+//and gives its result to every caller
+type flightGroup struct {
+	Lock  sync.Mutex
+	Calls map[string]*flightCall
+}
+
+type flightCall struct {
+	Done    chan struct{}
+	Result  interface{}
+	Err     error
+	//number of callers that still wait for the result
+	Waiters int
+	Cancel  context.CancelFunc
+}
+
+//do runs fn for the key unless the same key is already running,
+//in that case it waits for the running call and returns its result
+//shared is true if the result was produced by a call started by another caller
+//fn gets context that keeps values and deadline of the first caller
+//and is cancelled only when every caller has gone away
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (result interface{}, shared bool, err error) {
+	g.Lock.Lock()
+	if g.Calls == nil {
+		g.Calls = make(map[string]*flightCall)
+	}
+	call, shared := g.Calls[key]
+	if !shared {
+		call = g.start(ctx, key, fn)
+	}
+	call.Waiters++
+	g.Lock.Unlock()
+
+	select {
+	case <-call.Done:
+		return call.Result, shared, call.Err
+	case <-ctx.Done():
+		g.Lock.Lock()
+		call.Waiters--
+		if call.Waiters == 0 {
+			//nobody needs the result, new callers start a new call
+			call.Cancel()
+			if g.Calls[key] == call {
+				delete(g.Calls, key)
+			}
+		}
+		g.Lock.Unlock()
+		return nil, shared, ctx.Err()
+	}
+}
+
+//start runs fn in background, must be called with Lock held
+func (g *flightGroup) start(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) *flightCall {
+	callCtx, cancel := context.WithCancel(detachedContext { ctx })
+	if deadline, ok := ctx.Deadline(); ok {
+		callCtx, cancel = context.WithDeadline(detachedContext { ctx }, deadline)
+	}
+	call := &flightCall { Done: make(chan struct{}), Cancel: cancel }
+	g.Calls[key] = call
+	go func() {
+		//waiters are always released, panic of fn is reported to them
+		//as internal error the same way withRecovery reports it
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("panic in shared call %s: %v\n%s", key, p, debug.Stack())
+				call.Result = nil
+				call.Err = newInternalError(fmt.Errorf("%v", p))
+			}
+			g.Lock.Lock()
+			if g.Calls[key] == call {
+				delete(g.Calls, key)
+			}
+			g.Lock.Unlock()
+			cancel()
+			close(call.Done)
+		}()
+		call.Result, call.Err = fn(callCtx)
+		if call.Err != nil && callCtx.Err() == context.DeadlineExceeded {
+			call.Err = newTimeoutError()
+		}
+	}()
+	return call
+}
+
+
+//detachedContext keeps values of the parent context
+//but is not cancelled together with it
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
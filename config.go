@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"gopkg.in/yaml.v2"
+)
+
+
+const ConfigFilename  string = "config.json"
+const ConfigEnvPrefix string = "HISTORYAPI_"
+const ConfigEnvFile   string = ConfigEnvPrefix + "CONFIG"
+const DefaultPort     uint32 = 9000
+const DefaultElasticUrl string = "http://127.0.0.1:9200"
+const MaskedSecret    string = "******"
+
+var indexPrefixRegexp = regexp.MustCompile("^[a-z0-9][a-z0-9_.+-]*$")
+
+
+//defaultConfig returns config with default values
+//that are overridden by config file and environment variables
+func defaultConfig() Config {
+	prefixes := make(map[string]string)
+	for _, prefix := range IndexPrefixes {
+		prefixes[prefix] = prefix
+	}
+	return Config {
+		Port: DefaultPort,
+		ElasticUrl: DefaultElasticUrl,
+		RequestTimeoutMs: DefaultRequestTimeoutMs,
+		ReadTimeoutMs: DefaultReadTimeoutMs,
+		WriteTimeoutMs: DefaultWriteTimeoutMs,
+		IdleTimeoutMs: DefaultIdleTimeoutMs,
+		ShutdownTimeoutMs: DefaultShutdownTimeoutMs,
+		OtlpEndpoint: DefaultOtlpEndpoint,
+		TracingSampleRatio: 1,
+		IndexPrefixes: prefixes,
+		CacheMaxEntries: DefaultCacheMaxEntries,
+		ElasticHealthcheckIntervalMs: DefaultElasticHealthcheckIntervalMs,
+		ElasticHealthcheckTimeoutMs: DefaultElasticHealthcheckTimeoutMs,
+		ElasticMaxRetries: DefaultElasticMaxRetries,
+		ElasticRetryInitialBackoffMs: DefaultElasticRetryInitialBackoffMs,
+		ElasticRetryMaxBackoffMs: DefaultElasticRetryMaxBackoffMs,
+		ConfigPollIntervalMs: DefaultConfigPollIntervalMs,
+		CacheMaxAgeSeconds: DefaultCacheMaxAgeSeconds,
+		EndpointCosts: defaultEndpointCosts(),
+		ActionsMaxPageSize: DefaultActionsMaxPageSize,
+		ActionsMaxWindow: DefaultActionsMaxWindow,
+		ActionsLimitMode: ActionsLimitModeReject,
+		ActionsCountConcurrency: DefaultActionsCountConcurrency,
+		ActionsMode: ActionsModeNodeos,
+	}
+}
+
+
+//loadConfig builds config from defaults, config file and environment variables
+//later layers override earlier ones
+//missing file is an error only if its path was set explicitly
+func loadConfig(path string, explicit bool) (Config, error) {
+	config := defaultConfig()
+	err := readConfigFile(path, &config)
+	if err != nil {
+		if !os.IsNotExist(err) || explicit {
+			return config, err
+		}
+	}
+	err = applyEnv(&config, os.Environ())
+	if err != nil {
+		return config, err
+	}
+	//prefixes that are not set keep their default names
+	for _, prefix := range IndexPrefixes {
+		if len(config.IndexPrefixes[prefix]) == 0 {
+			config.IndexPrefixes[prefix] = prefix
+		}
+	}
+	return config, nil
+}
+
+
+//readConfigFile decodes json or yaml file into config
+//unknown properties are reported as errors to catch typos
+func readConfigFile(path string, config *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".yaml" || ext == ".yml" {
+		var tree interface{}
+		err = yaml.Unmarshal(data, &tree)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %s", path, err)
+		}
+		//yaml is converted to json so that json tags of Config are used for both formats
+		data, err = json.Marshal(yamlToJson(tree))
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %s", path, err)
+		}
+	}
+	if len(strings.TrimSpace(string(data))) == 0 || strings.TrimSpace(string(data)) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(config)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return nil
+}
+
+//yamlToJson replaces maps with interface{} keys produced by yaml decoder
+//with maps that can be encoded to json
+func yamlToJson(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = yamlToJson(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = yamlToJson(item)
+		}
+	}
+	return value
+}
+
+
+//applyEnv overrides config properties with environment variables
+//named as HISTORYAPI_ followed by the property name in upper case,
+//e.g. HISTORYAPI_ELASTIC_URL for elastic_url
+//lists can be comma separated, lists and maps can be given as json
+func applyEnv(config *Config, environ []string) error {
+	env := make(map[string]string)
+	for _, item := range environ {
+		if i := strings.Index(item, "="); i > 0 {
+			env[item[:i]] = item[i+1:]
+		}
+	}
+	errs := make([]string, 0)
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+		key := ConfigEnvPrefix + strings.ToUpper(name)
+		raw, ok := env[key]
+		if !ok {
+			continue
+		}
+		field := value.Field(i)
+		var err error
+		switch {
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String &&
+			!strings.HasPrefix(strings.TrimSpace(raw), "["):
+			items := make([]string, 0)
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); len(item) > 0 {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+		case field.Kind() == reflect.Slice || field.Kind() == reflect.Map:
+			ptr := reflect.New(field.Type())
+			if json.Unmarshal([]byte(raw), ptr.Interface()) != nil {
+				err = errors.New("must be json " + field.Kind().String())
+			} else {
+				field.Set(ptr.Elem())
+			}
+		default:
+			err = setValue(field, raw)
+		}
+		if err != nil {
+			errs = append(errs, key + ": " + err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("invalid environment variables:\n  " + strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+
+//validate checks every config property and returns error
+//that lists all invalid properties
+func (c *Config) validate() error {
+	errs := make([]string, 0)
+	fail := func(property string, message string) {
+		errs = append(errs, property + ": " + message)
+	}
+	checkUrl := func(property string, value string) {
+		if len(value) == 0 {
+			fail(property, "property is required")
+			return
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			fail(property, "must be http or https url, got '" + value + "'")
+		}
+	}
+	notNegative := func(property string, value int64) {
+		if value < 0 {
+			fail(property, "must not be negative")
+		}
+	}
+	checkFile := func(property string, path string) {
+		if len(path) == 0 {
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			fail(property, "can't read file: " + err.Error())
+		}
+	}
+
+	if c.Port == 0 || c.Port > 65535 {
+		fail("port", "must be between 1 and 65535")
+	}
+	if len(c.ElasticUrls) == 0 {
+		checkUrl("elastic_url", c.ElasticUrl)
+	}
+	for i, u := range c.ElasticUrls {
+		checkUrl(fmt.Sprintf("elastic_urls[%d]", i), u)
+	}
+	checkUrl("seed_node", c.SeedNode)
+	notNegative("request_timeout_ms", c.RequestTimeoutMs)
+	endpoints := make([]string, 0, len(c.EndpointTimeoutsMs))
+	for endpoint := range c.EndpointTimeoutsMs {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		notNegative("endpoint_timeouts_ms." + endpoint, c.EndpointTimeoutsMs[endpoint])
+	}
+	notNegative("read_timeout_ms", c.ReadTimeoutMs)
+	notNegative("write_timeout_ms", c.WriteTimeoutMs)
+	notNegative("idle_timeout_ms", c.IdleTimeoutMs)
+	notNegative("shutdown_timeout_ms", c.ShutdownTimeoutMs)
+	notNegative("cors_max_age_seconds", int64(c.CorsMaxAgeSeconds))
+	notNegative("max_concurrent_requests", int64(c.MaxConcurrentRequests))
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		fail("tracing_sample_ratio", "must be between 0 and 1")
+	}
+	if c.TracingEnabled && len(c.OtlpEndpoint) == 0 {
+		fail("otlp_endpoint", "property is required when tracing is enabled")
+	}
+
+	known := make(map[string]bool)
+	for _, prefix := range IndexPrefixes {
+		known[prefix] = true
+	}
+	prefixes := make([]string, 0, len(c.IndexPrefixes))
+	for prefix := range c.IndexPrefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if !known[prefix] {
+			fail("index_prefixes." + prefix, "unknown index type, must be one of " + strings.Join(IndexPrefixes, ", "))
+		} else if !indexPrefixRegexp.MatchString(c.IndexPrefixes[prefix]) {
+			fail("index_prefixes." + prefix, "invalid index prefix '" + c.IndexPrefixes[prefix] + "'")
+		}
+	}
+
+	notNegative("cache_max_entries", int64(c.CacheMaxEntries))
+	notNegative("cache_max_age_seconds", int64(c.CacheMaxAgeSeconds))
+	notNegative("disk_cache_max_mb", c.DiskCacheMaxMb)
+	if c.DiskCacheMaxMb > 0 && len(c.DiskCacheDir) == 0 {
+		fail("disk_cache_dir", "property is required when disk_cache_max_mb is set")
+	}
+
+	if len(c.ElasticUsername) > 0 && len(c.ElasticPassword) == 0 {
+		fail("elastic_password", "property is required when elastic_username is set")
+	}
+	if len(c.ElasticPassword) > 0 && len(c.ElasticUsername) == 0 {
+		fail("elastic_username", "property is required when elastic_password is set")
+	}
+	if len(c.ElasticApiKey) > 0 && len(c.ElasticUsername) > 0 {
+		fail("elastic_api_key", "can't be used together with elastic_username")
+	}
+	checkFile("elastic_ca_file", c.ElasticCaFile)
+	if len(c.ElasticCaFile) > 0 {
+		if _, err := newElasticHttpClient(Config { ElasticCaFile: c.ElasticCaFile }); err != nil {
+			fail("elastic_ca_file", err.Error())
+		}
+	}
+	if len(c.ElasticCertFile) > 0 && len(c.ElasticKeyFile) == 0 {
+		fail("elastic_key_file", "property is required when elastic_cert_file is set")
+	}
+	if len(c.ElasticKeyFile) > 0 && len(c.ElasticCertFile) == 0 {
+		fail("elastic_cert_file", "property is required when elastic_key_file is set")
+	}
+	if len(c.ElasticCertFile) > 0 && len(c.ElasticKeyFile) > 0 {
+		if _, err := tls.LoadX509KeyPair(c.ElasticCertFile, c.ElasticKeyFile); err != nil {
+			fail("elastic_cert_file", "can't load client certificate: " + err.Error())
+		}
+	}
+	notNegative("elastic_healthcheck_interval_ms", c.ElasticHealthcheckIntervalMs)
+	notNegative("elastic_healthcheck_timeout_ms", c.ElasticHealthcheckTimeoutMs)
+	notNegative("elastic_max_retries", int64(c.ElasticMaxRetries))
+	notNegative("elastic_retry_initial_backoff_ms", c.ElasticRetryInitialBackoffMs)
+	notNegative("elastic_retry_max_backoff_ms", c.ElasticRetryMaxBackoffMs)
+	notNegative("config_poll_interval_ms", c.ConfigPollIntervalMs)
+
+	keys := make(map[string]bool)
+	for i, key := range c.ApiKeys {
+		property := fmt.Sprintf("api_keys[%d]", i)
+		if len(key.Key) == 0 {
+			fail(property + ".key", "property is required")
+		} else if keys[key.Key] {
+			fail(property + ".key", "duplicate api key")
+		}
+		keys[key.Key] = true
+		if key.RatePerSecond < 0 {
+			fail(property + ".rate_per_second", "must not be negative")
+		}
+		if key.Burst < 0 {
+			fail(property + ".burst", "must not be negative")
+		}
+	}
+	if c.RateLimitPerSecond < 0 {
+		fail("rate_limit_per_second", "must not be negative")
+	}
+	if c.RateLimitBurst < 0 {
+		fail("rate_limit_burst", "must not be negative")
+	}
+	costs := make([]string, 0, len(c.EndpointCosts))
+	for endpoint := range c.EndpointCosts {
+		costs = append(costs, endpoint)
+	}
+	sort.Strings(costs)
+	for _, endpoint := range costs {
+		if c.EndpointCosts[endpoint] <= 0 {
+			fail("endpoint_costs." + endpoint, "must be positive")
+		}
+	}
+	notNegative("actions_max_page_size", c.ActionsMaxPageSize)
+	notNegative("actions_max_indices", int64(c.ActionsMaxIndices))
+	notNegative("actions_max_window", c.ActionsMaxWindow)
+	notNegative("actions_count_concurrency", int64(c.ActionsCountConcurrency))
+	if !containsString(ActionsModes, c.ActionsMode) {
+		fail("actions_mode", "must be one of " + strings.Join(ActionsModes, ", "))
+	}
+	if c.ActionsLimitMode != ActionsLimitModeReject && c.ActionsLimitMode != ActionsLimitModeClamp {
+		fail("actions_limit_mode", "must be " + ActionsLimitModeReject + " or " + ActionsLimitModeClamp)
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid config:\n  " + strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+
+//masked returns copy of config with secrets replaced
+//so that it can be printed or logged
+func (c Config) masked() Config {
+	if len(c.ElasticPassword) > 0 {
+		c.ElasticPassword = MaskedSecret
+	}
+	if len(c.ElasticApiKey) > 0 {
+		c.ElasticApiKey = MaskedSecret
+	}
+	//keys are copied so that config itself is not changed
+	keys := make([]ApiKeyConfig, len(c.ApiKeys))
+	for i, key := range c.ApiKeys {
+		key.Key = MaskedSecret
+		keys[i] = key
+	}
+	if c.ApiKeys != nil {
+		c.ApiKeys = keys
+	}
+	return c
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+
+//error codes of the history api
+//they are reported in error.code field the same way nodeos reports fc exception codes
+const InternalErrorCode         int = 3900000
+const BadParamsErrorCode        int = 3900001
+const NotFoundErrorCode         int = 3900002
+const ElasticErrorCode          int = 3900003
+const NodeErrorCode             int = 3900004
+const TimeoutErrorCode          int = 3900005
+const MethodNotAllowedErrorCode int = 3900006
+const ServerBusyErrorCode       int = 3900007
+const UnauthorizedErrorCode     int = 3900008
+const TooManyRequestsErrorCode  int = 3900009
+const QueryLimitErrorCode       int = 3900010
+
+
+//ApiError is an error that knows how it must be reported to the client
+type ApiError struct {
+	Status  int
+	Code    int
+	Name    string
+	What    string
+	Details []ErrorDetail
+	Err     error
+}
+
+func (e *ApiError) Error() string {
+	if e.Err != nil {
+		return e.What + ": " + e.Err.Error()
+	}
+	return e.What
+}
+
+//withDetail adds detail message to the error and returns it
+func (e *ApiError) withDetail(message string) *ApiError {
+	e.Details = append(e.Details, ErrorDetail { Message: message })
+	return e
+}
+
+func newApiError(status int, code int, name string, what string, err error) *ApiError {
+	e := &ApiError { Status: status, Code: code, Name: name, What: what, Err: err }
+	e.Details = make([]ErrorDetail, 0)
+	if err != nil {
+		e.Details = append(e.Details, ErrorDetail { Message: err.Error() })
+	}
+	return e
+}
+
+func newInternalError(err error) *ApiError {
+	return newApiError(http.StatusInternalServerError, InternalErrorCode,
+		"internal_exception", "Internal error", err)
+}
+
+func newBadParamsError(message string) *ApiError {
+	e := newApiError(http.StatusBadRequest, BadParamsErrorCode,
+		"bad_params_exception", "Invalid request parameters", nil)
+	return e.withDetail(message)
+}
+
+func newNotFoundError(message string) *ApiError {
+	e := newApiError(http.StatusNotFound, NotFoundErrorCode,
+		"not_found_exception", "Requested data not found", nil)
+	return e.withDetail(message)
+}
+
+func newElasticError(err error) *ApiError {
+	if err == nil {
+		err = errors.New("Empty response from Elasticsearch")
+	}
+	return newApiError(http.StatusInternalServerError, ElasticErrorCode,
+		"elasticsearch_exception", "Elasticsearch request failed", err)
+}
+
+func newNodeError(err error) *ApiError {
+	return newApiError(http.StatusBadGateway, NodeErrorCode,
+		"node_exception", "Node request failed", err)
+}
+
+func newTimeoutError() *ApiError {
+	e := newApiError(http.StatusGatewayTimeout, TimeoutErrorCode,
+		"timeout_exception", "Request timed out", nil)
+	return e.withDetail("Request exceeded its deadline")
+}
+
+func newMethodNotAllowedError() *ApiError {
+	e := newApiError(http.StatusMethodNotAllowed, MethodNotAllowedErrorCode,
+		"method_not_allowed_exception", "Invalid request method", nil)
+	return e.withDetail("Only GET and POST requests are supported")
+}
+
+func newServerBusyError() *ApiError {
+	e := newApiError(http.StatusServiceUnavailable, ServerBusyErrorCode,
+		"server_busy_exception", "Server is busy", nil)
+	return e.withDetail("Too many concurrent requests, try again later")
+}
+
+func newUnauthorizedError() *ApiError {
+	e := newApiError(http.StatusUnauthorized, UnauthorizedErrorCode,
+		"unauthorized_exception", "Invalid api key", nil)
+	return e.withDetail("Api key is not known to the server")
+}
+
+func newTooManyRequestsError() *ApiError {
+	e := newApiError(http.StatusTooManyRequests, TooManyRequestsErrorCode,
+		"too_many_requests_exception", "Rate limit exceeded", nil)
+	return e.withDetail("Too many requests, retry after the time in Retry-After header")
+}
+
+func newQueryLimitError(message string) *ApiError {
+	e := newApiError(http.StatusBadRequest, QueryLimitErrorCode,
+		"query_limit_exceeded_exception", "Query exceeds server limits", nil)
+	return e.withDetail(message)
+}
+
+
+//toApiError converts any error returned by backend calls to ApiError
+//errors that are not ApiError are treated as internal errors
+//unless the request context has been cancelled or timed out
+func toApiError(ctx context.Context, err error) *ApiError {
+	if ctx != nil && ctx.Err() == context.DeadlineExceeded {
+		return newTimeoutError()
+	}
+	if e, ok := err.(*ApiError); ok && e != nil {
+		return e
+	}
+	return newInternalError(err)
+}
+
+
+//writeError encodes error in the nodeos error response format and sends it
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e := toApiError(r.Context(), err)
+	response := ErrorResult { Code: e.Status, Message: http.StatusText(e.Status),
+		Error: ErrorInfo { Code: e.Code, Name: e.Name, What: e.What, Details: e.Details } }
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(response)
+}
+
+
+//writeResult encodes result as json and sends it
+func writeResult(w http.ResponseWriter, r *http.Request, result interface{}) {
+	b, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, r, newInternalError(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
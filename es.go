@@ -5,13 +5,15 @@ import (
 	"encoding/json"
 	"github.com/olivere/elastic"
 	"context"
-	"net/http"
-	"bufio"
+	"fmt"
+	"log"
 	"regexp"
+	"runtime/debug"
 	"strings"
 	"math"
-	"strconv"
 	"sort"
+	"sync"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const AccountsIndex          string = "accounts"
@@ -22,33 +24,37 @@ const ActionTracesIndex      string = "action_traces"
 
 const MaxQuerySize int = 10000
 
+//modes of get_actions that choose which action traces belong to the account
+const ActionsModeNodeos   string = "nodeos"
+const ActionsModeReceiver string = "receiver"
+const ActionsModeActor    string = "actor"
+const ActionsModeUnique   string = "unique"
+
+var ActionsModes = []string {
+	ActionsModeNodeos,
+	ActionsModeReceiver,
+	ActionsModeActor,
+	ActionsModeUnique }
+
 
 //get index list from ES and parse indices from it
 //return a map where every prefix from input array is a key
 //and a value is vector of corresponding indices
-func getIndices(esUrl string, prefixes []string) map[string][]string {
+//the list is requested with the same client and credentials as other queries
+func getIndices(ctx context.Context, client *elastic.Client, prefixes []string) map[string][]string {
 	result := make(map[string][]string)
-	resp, err := http.Get(esUrl + "/_cat/indices?v&s=index")
+	rows, err := client.CatIndices().Columns("index").Sort("index").Do(ctx)
 	if err != nil {
 		return result
 	}
-	defer resp.Body.Close()
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Split(bufio.ScanLines)
-
-	var lines []string
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-	}
 	for _, prefix := range prefixes {
-		r, err := regexp.Compile("\\s" + prefix + "-(\\d)*\\s")
+		r, err := regexp.Compile("^" + regexp.QuoteMeta(prefix) + "-(\\d)*$")
 		if err != nil {
 			return result
 		}
-		for _, line := range lines {
-			match := r.FindString(line)
-			if len(match) != 0 {
-				result[prefix] = append(result[prefix], strings.TrimSpace(match))
+		for _, row := range rows {
+			if r.MatchString(row.Index) {
+				result[prefix] = append(result[prefix], row.Index)
 			}
 		}
 	}
@@ -79,53 +85,97 @@ func convertAbiToBytes(actionTraces []TransactionTraceActionTrace) {
 }
 
 
-func findActionTrace(txTrace *TransactionTrace, actionSeq json.RawMessage) (*TransactionTraceActionTrace, error) {
-	actionTraces := txTrace.ActionTraces
-	trace := new(TransactionTraceActionTrace)
-	for len(actionTraces) > 0 {
-		trace = &actionTraces[0]
-		actionTraces = append(actionTraces[1:len(actionTraces)], trace.InlineTraces...)
-		var receipt map[string]*json.RawMessage
-		err := json.Unmarshal(trace.Receipt, &receipt)
-		if err != nil || receipt["global_sequence"] == nil {
-			continue
-		}
-		var tmp interface{}
-		var seq string
-		var targetSeq string
-		err = json.Unmarshal(*receipt["global_sequence"], &tmp)
-		if err != nil {
-			continue
+//sequenceString returns sequence number that is indexed
+//either as json number or as string in decimal form
+func sequenceString(raw json.RawMessage) (string, bool) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, len(s) > 0
+	}
+	var n json.Number
+	if json.Unmarshal(raw, &n) == nil {
+		return n.String(), true
+	}
+	return "", false
+}
+
+
+//traceNode is action trace visited by findActionTrace
+//with its parent and the action that created it
+type traceNode struct {
+	Trace   *TransactionTraceActionTrace
+	Receipt map[string]json.RawMessage
+	Parent  *traceNode
+	Creator *traceNode
+	Depth   int
+}
+
+//findActionTrace walks the trace tree of the transaction breadth first
+//and returns action trace with the global sequence and its context in the tree
+//notifications are inline traces of the action they notify with the same act_digest,
+//their creator is the creator of the notified action, for other inline actions
+//the creator is the trace they were sent from
+func findActionTrace(txTrace *TransactionTrace, actionSeq json.RawMessage) (*TransactionTraceActionTrace, *ActionTraceContext, error) {
+	targetSeq, ok := sequenceString(actionSeq)
+	if !ok {
+		return nil, nil, newNotFoundError("Action trace not found in transaction trace")
+	}
+	queue := make([]*traceNode, 0, len(txTrace.ActionTraces))
+	for i := range txTrace.ActionTraces {
+		queue = append(queue, &traceNode { Trace: &txTrace.ActionTraces[i] })
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		json.Unmarshal(node.Trace.Receipt, &node.Receipt)
+		if node.Parent != nil {
+			node.Creator = node.Parent
+			if len(node.Receipt["act_digest"]) > 0 &&
+				string(node.Receipt["act_digest"]) == string(node.Parent.Receipt["act_digest"]) {
+				node.Creator = node.Parent.Creator
+			}
 		}
-		if i, ok := tmp.(float64); ok {
-			seq = strconv.FormatUint(uint64(i), 10)
-		} else if s, ok := tmp.(string); ok {
-			seq = s
+		for i := range node.Trace.InlineTraces {
+			queue = append(queue, &traceNode { Trace: &node.Trace.InlineTraces[i], Parent: node, Depth: node.Depth + 1 })
 		}
-		err = json.Unmarshal(actionSeq, &tmp)
-		if err != nil {
+		if node.Receipt["global_sequence"] == nil {
 			continue
 		}
-		if i, ok := tmp.(float64); ok {
-			targetSeq = strconv.FormatUint(uint64(i), 10)
-		} else if s, ok := tmp.(string); ok {
-			targetSeq = s
-		}
-		if seq == targetSeq {
-			return trace, nil
+		if seq, ok := sequenceString(node.Receipt["global_sequence"]); ok && seq == targetSeq {
+			return node.Trace, newActionTraceContext(txTrace, node), nil
 		}
 	}
-	return nil, errors.New("Action trace not found in transaction trace")
+	return nil, nil, newNotFoundError("Action trace not found in transaction trace")
+}
+
+func newActionTraceContext(txTrace *TransactionTrace, node *traceNode) *ActionTraceContext {
+	traceContext := &ActionTraceContext { Depth: node.Depth,
+		TrxStatus: txTrace.Receipt["status"],
+		CpuUsageUs: txTrace.Receipt["cpu_usage_us"],
+		NetUsageWords: txTrace.Receipt["net_usage_words"] }
+	if node.Parent != nil {
+		traceContext.ParentActionSeq = node.Parent.Receipt["global_sequence"]
+	}
+	if node.Creator != nil {
+		traceContext.CreatorAction = &CreatorAction { GlobalActionSeq: node.Creator.Receipt["global_sequence"],
+			Account: node.Creator.Trace.Act.Account, Name: node.Creator.Trace.Act.Name,
+			Receiver: node.Creator.Receipt["receiver"] }
+	}
+	return traceContext
 }
 
-func getActionTrace(client *elastic.Client, txId string, actionSeq json.RawMessage, indices map[string][]string) (json.RawMessage, error) {
+func getActionTrace(ctx context.Context, client *elastic.Client, txId string, actionSeq json.RawMessage, indices map[string][]string) (_ json.RawMessage, _ *ActionTraceContext, err error) {
+	ctx, span := startSpan(ctx, "getActionTrace",
+		attribute.String("trx_id", txId),
+		attribute.StringSlice("indices", indices[TransactionTracesIndexPrefix]))
+	defer func() { endSpan(span, err) }()
 	multiGet := client.MultiGet()
 	for _, index := range indices[TransactionTracesIndexPrefix] {
 		multiGet.Add(elastic.NewMultiGetItem().Index(index).Id(txId))
 	}
-	mgetResult, err := multiGet.Do(context.Background())
+	mgetResult, err := multiGet.Do(ctx)
 	if err != nil || mgetResult == nil || mgetResult.Docs == nil {
-		return nil, err
+		return nil, nil, newElasticError(err)
 	}
 	var getResult *elastic.GetResult
 	for _, doc := range mgetResult.Docs {
@@ -136,16 +186,16 @@ func getActionTrace(client *elastic.Client, txId string, actionSeq json.RawMessa
 	}
 
 	if getResult == nil || !getResult.Found || getResult.Source == nil {
-		return nil, errors.New("Action trace not found")
+		return nil, nil, newNotFoundError("Action trace not found")
 	}
 	var txTrace TransactionTrace
 	err = json.Unmarshal(*getResult.Source, &txTrace)
 	if err != nil {
-		return nil, errors.New("Failed to parse ES response")
+		return nil, nil, newElasticError(errors.New("Failed to parse ES response"))
 	}
-	trace, err := findActionTrace(&txTrace, actionSeq)
+	trace, traceContext, err := findActionTrace(&txTrace, actionSeq)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	//replace json abi with bytes
 	if trace.Act.Account == "eosio" && trace.Act.Name == "setabi" &&
@@ -158,26 +208,230 @@ func getActionTrace(client *elastic.Client, txId string, actionSeq json.RawMessa
 	convertAbiToBytes(trace.InlineTraces)
 	bytes, err := json.Marshal(trace)
 	if err != nil {
-		return nil, errors.New("Failed to parse ES response")
+		return nil, nil, newElasticError(errors.New("Failed to parse ES response"))
+	}
+	return bytes, traceContext, nil
+}
+
+
+//actionsQuery returns query of action traces of the account for the mode of request
+//the same query is used for counting and searching so that positions match
+//nodeos: the account is receiver or actor, every notification is a separate action
+//receiver: the account is receiver
+//actor: the account is actor, including notifications of its actions
+//unique: the same traces and positions as nodeos, repeated traces of an action
+//are removed from the page by repeatedActions, they are not removed from counts
+func actionsQuery(params GetActionsParams) elastic.Query {
+	switch params.Mode {
+	case ActionsModeReceiver:
+		return elastic.NewMatchQuery("receipt.receiver", params.AccountName)
+	case ActionsModeActor:
+		return elastic.NewMatchQuery("act.authorization.actor", params.AccountName)
 	}
-	return bytes, nil
+	return elastic.NewMultiMatchQuery(params.AccountName, "receipt.receiver", "act.authorization.actor")
 }
 
 
-func countActions(client *elastic.Client, params GetActionsParams, index string) (int64, error) {
+//repeatedActions groups traces of the account by trx_id and act_digest in all indices
+//and marks hits that are not the trace with the lowest global_sequence in their group,
+//so every action is returned once, at the first trace of the account
+//the first trace may be on an earlier page, then the action is not repeated here
+//grouping needs trx_id and receipt.act_digest to be keyword fields
+func repeatedActions(ctx context.Context, client *elastic.Client, params GetActionsParams, indices []string, hits []ActionTrace) (_ []bool, err error) {
+	ctx, span := startSpan(ctx, "repeatedActions",
+		attribute.String("account_name", params.AccountName),
+		attribute.StringSlice("indices", indices))
+	defer func() { endSpan(span, err) }()
+	trxIds := make([]interface{}, 0, len(hits))
+	digests := make([]interface{}, 0, len(hits))
+	for _, hit := range hits {
+		var digest string
+		json.Unmarshal(hit.Receipt.ActDigest, &digest)
+		trxIds = append(trxIds, hit.TrxId)
+		digests = append(digests, digest)
+	}
+	query := elastic.NewBoolQuery().Filter(actionsQuery(params),
+		elastic.NewTermsQuery("trx_id", trxIds...),
+		elastic.NewTermsQuery("receipt.act_digest", digests...))
+	first := elastic.NewTopHitsAggregation().
+		Sort("receipt.global_sequence", true).
+		Size(1).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("receipt.global_sequence"))
+	groups := elastic.NewTermsAggregation().Field("trx_id").Size(len(hits)).
+		SubAggregation("digests", elastic.NewTermsAggregation().Field("receipt.act_digest").Size(len(hits)).
+			SubAggregation("first", first))
+	searchResult, err := client.Search(indices...).
+		Query(query).
+		Size(0).
+		Aggregation("trx_ids", groups).
+		Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unique mode needs keyword fields trx_id and receipt.act_digest: %v", err)
+	}
+	firstSeqs := make(map[string]string)
+	if terms, found := searchResult.Aggregations.Terms("trx_ids"); found {
+		for _, trxBucket := range terms.Buckets {
+			digestTerms, found := trxBucket.Terms("digests")
+			if !found {
+				continue
+			}
+			for _, bucket := range digestTerms.Buckets {
+				topHits, found := bucket.TopHits("first")
+				if !found || topHits.Hits == nil || len(topHits.Hits.Hits) == 0 || topHits.Hits.Hits[0].Source == nil {
+					continue
+				}
+				var trace ActionTrace
+				if json.Unmarshal(*topHits.Hits.Hits[0].Source, &trace) != nil {
+					continue
+				}
+				if seq, ok := sequenceString(trace.Receipt.GlobalSequence); ok {
+					firstSeqs[fmt.Sprint(trxBucket.Key) + "/" + fmt.Sprint(bucket.Key)] = seq
+				}
+			}
+		}
+	}
+	repeated := make([]bool, len(hits))
+	for i, hit := range hits {
+		seq, _ := sequenceString(hit.Receipt.GlobalSequence)
+		firstSeq, ok := firstSeqs[fmt.Sprint(trxIds[i]) + "/" + fmt.Sprint(digests[i])]
+		//every hit belongs to a group, a missing one means that the fields are not keywords
+		if !ok {
+			return nil, fmt.Errorf("action %s is not found by trx_id and receipt.act_digest, unique mode needs keyword fields trx_id and receipt.act_digest", seq)
+		}
+		repeated[i] = seq != firstSeq
+	}
+	return repeated, nil
+}
+
+
+func countActions(ctx context.Context, client *elastic.Client, params GetActionsParams, index string) (count int64, err error) {
+	ctx, span := startSpan(ctx, "countActions",
+		attribute.String("account_name", params.AccountName),
+		attribute.String("index", index))
+	defer func() {
+		span.SetAttributes(attribute.Int64("count", count))
+		endSpan(span, err)
+	}()
 	query := elastic.NewBoolQuery()
-	query = query.Filter(elastic.NewMultiMatchQuery(params.AccountName, "receipt.receiver", "act.authorization.actor"))
-	count, err := client.Count(index).
+	query = query.Filter(actionsQuery(params))
+	return client.Count(index).
 		Query(query).
-		Do(context.Background())
-	return count, err
+		Do(ctx)
+}
+
+
+//countActionsBefore counts actions of the account with global sequence
+//lower than the given one in all indices with a single request
+//it is account_action_seq of the action with this global sequence,
+//nodeos history_plugin numbers actions of an account the same way
+func countActionsBefore(ctx context.Context, client *elastic.Client, params GetActionsParams, indices []string, globalSeq json.RawMessage) (count int64, err error) {
+	seq, ok := sequenceString(globalSeq)
+	ctx, span := startSpan(ctx, "countActionsBefore",
+		attribute.String("account_name", params.AccountName),
+		attribute.String("global_sequence", seq))
+	defer func() {
+		span.SetAttributes(attribute.Int64("count", count))
+		endSpan(span, err)
+	}()
+	if !ok {
+		return 0, errors.New("invalid global_sequence " + string(globalSeq))
+	}
+	query := elastic.NewBoolQuery()
+	query = query.Filter(actionsQuery(params))
+	query = query.Filter(elastic.NewRangeQuery("receipt.global_sequence").Lt(seq))
+	return client.Count(indices...).
+		Query(query).
+		Do(ctx)
+}
+
+
+//msearchError describes failed response of one search in msearch
+func msearchError(index string, resp *elastic.SearchResult) error {
+	if resp != nil && resp.Error != nil {
+		return fmt.Errorf("search in %s failed: %s: %s", index, resp.Error.Type, resp.Error.Reason)
+	}
+	return fmt.Errorf("search in %s returned no hits", index)
+}
+
+
+//countActionsPerIndex counts actions of the account in every index
+//with at most concurrency count requests at the same time
+//the first failed count cancels the others and fails the request
+//because the page can't be located without every count
+func countActionsPerIndex(ctx context.Context, client *elastic.Client, params GetActionsParams, indices []string, concurrency int) ([]int64, error) {
+	counts := make([]int64, len(indices))
+	if concurrency <= 0 {
+		concurrency = DefaultActionsCountConcurrency
+	}
+	countCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var firstErr error
+	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(indices); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			//panic in a worker can't reach withRecovery of the handler
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("panic in count of %s actions: %v\n%s", params.AccountName, p, debug.Stack())
+					fail(newInternalError(fmt.Errorf("%v", p)))
+					for range jobs {
+					}
+				}
+			}()
+			for i := range jobs {
+				//workers only drain the jobs after the first error
+				if countCtx.Err() != nil {
+					continue
+				}
+				count, err := countActions(countCtx, client, params, indices[i])
+				if err != nil {
+					fail(err)
+					continue
+				}
+				counts[i] = count
+			}
+		}()
+	}
+	for i := range indices {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if firstErr != nil {
+		if e, ok := firstErr.(*ApiError); ok {
+			return nil, e
+		}
+		return nil, newElasticError(firstErr)
+	}
+	return counts, nil
 }
 
 
-func getActions(client *elastic.Client, params GetActionsParams, indices map[string][]string) (*GetActionsResult, error) {
-	result := new(GetActionsResult)
+func getActions(ctx context.Context, client *elastic.Client, params GetActionsParams, indices map[string][]string, limits ActionsQueryLimits) (result *GetActionsResult, err error) {
+	ctx, span := startSpan(ctx, "getActions",
+		attribute.String("account_name", params.AccountName),
+		attribute.String("mode", params.Mode),
+		attribute.Int64("pos", *params.Pos),
+		attribute.Int64("offset", *params.Offset))
+	defer func() { endSpan(span, err) }()
+	result = new(GetActionsResult)
 	result.Actions = make([]Action, 0)
 	ascOrder := true
+	//asc page with negative offset ends at pos, desc pages start at the newest action
+	endsAtPos := *params.Pos != -1 && *params.Offset < 0
 	//deal with request params
 	if *params.Pos == -1 {
 		ascOrder = false
@@ -202,6 +456,12 @@ func getActions(client *elastic.Client, params GetActionsParams, indices map[str
 		*params.Offset += *params.Pos
 		*params.Pos = 0
 	}
+	//expensive queries are rejected or clamped before any request to ES
+	var pageLimit, searchesLimit *QueryLimit
+	*params.Pos, *params.Offset, pageLimit, err = limits.checkPageSize(*params.Pos, *params.Offset, endsAtPos)
+	if err != nil {
+		return nil, err
+	}
 
 	//reverse index list if sort order is desc
 	indexNum := len(indices[ActionTracesIndexPrefix])
@@ -219,14 +479,9 @@ func getActions(client *elastic.Client, params GetActionsParams, indices map[str
 	var lastSize *int
 	targetIndices := make([]string, 0)
 	actionsPerTargetIndex := make([]int64, 0)
-	actionsPerIndex := make([]int64, 0, indexNum)
-	for _, index := range orderedIndices {
-		count, _ := countActions(client, params, index)
-		actionsPerIndex = append(actionsPerIndex, count)
-	}
-	totalActions := uint64(0)
-	for _, value := range actionsPerIndex {
-		totalActions += uint64(value)
+	actionsPerIndex, err := countActionsPerIndex(ctx, client, params, orderedIndices, limits.CountConcurrency)
+	if err != nil {
+		return nil, err
 	}
 	counter := int64(0)
 	i := 0
@@ -252,32 +507,59 @@ func getActions(client *elastic.Client, params GetActionsParams, indices map[str
 		return result, nil
 	}
 	
-	query := elastic.NewBoolQuery()
-	query = query.Must(elastic.NewMultiMatchQuery(params.AccountName, "receipt.receiver", "act.authorization.actor"))
-	msearch := client.MultiSearch()
+
+	searches := make([]actionsSearch, 0, len(targetIndices))
 	for i, index := range targetIndices {
-		sreq := elastic.NewSearchRequest().
-			Index(index).Query(query).
-			Sort("receipt.global_sequence", ascOrder)
+		search := actionsSearch { Index: index, Count: actionsPerTargetIndex[i], Size: actionsPerTargetIndex[i] }
 		if i == 0 && startPos != nil {
-			sreq.From(*startPos)
+			search.From = int64(*startPos)
+			search.Size -= search.From
 		}
+		//lastSize is the end of the page in the last index
 		if i == len(targetIndices) - 1 && lastSize != nil {
-			sreq.Size(*lastSize)
-		} else {
-			sreq.Size(int(actionsPerTargetIndex[i]))
+			search.Size = int64(*lastSize) - search.From
 		}
+		searches = append(searches, search)
+	}
+	searches, searchesLimit, err = limits.checkSearches(searches)
+	if err != nil {
+		return nil, err
+	}
+	//short page is reported so that it is not taken for the end of the history
+	result.QueryLimit = pageLimit
+	if searchesLimit != nil {
+		result.QueryLimit = searchesLimit
+	}
+	span.SetAttributes(attribute.Bool("clamped", result.QueryLimit != nil))
+
+	query := elastic.NewBoolQuery()
+	query = query.Filter(actionsQuery(params))
+	msearch := client.MultiSearch()
+	targetIndices = targetIndices[:0]
+	for _, search := range searches {
+		sreq := elastic.NewSearchRequest().
+			Index(search.Index).Query(query).
+			Sort("receipt.global_sequence", ascOrder).
+			From(int(search.From)).
+			Size(int(search.Size))
 		msearch.Add(sreq)
+		targetIndices = append(targetIndices, search.Index)
 	}
-	msearchResult, err := msearch.Do(context.Background())
+	msearchResult, err := msearch.Do(ctx)
 	if err != nil || msearchResult == nil || msearchResult.Responses == nil {
-		return nil, err
+		return nil, newElasticError(err)
+	}
+	if len(msearchResult.Responses) != len(searches) {
+		return nil, newElasticError(fmt.Errorf("msearch returned %d responses for %d searches",
+			len(msearchResult.Responses), len(searches)))
 	}
 
+	//account_action_seq is counted from the oldest hit, so a page with
+	//a hole left by a failed search would get wrong numbers
 	var searchHits []elastic.SearchHit
-	for _, resp := range msearchResult.Responses {
-		if resp == nil || resp.Error != nil {
-			continue
+	for i, resp := range msearchResult.Responses {
+		if resp == nil || resp.Error != nil || resp.Hits == nil {
+			return nil, newElasticError(msearchError(searches[i].Index, resp))
 		}
 		for _, hit := range resp.Hits.Hits {
 			if hit != nil && len(searchHits) < int(*params.Offset) {
@@ -289,40 +571,95 @@ func getActions(client *elastic.Client, params GetActionsParams, indices map[str
 		}
 	}
 	msearchResult.Responses = nil
+	span.SetAttributes(attribute.StringSlice("indices", targetIndices),
+		attribute.Int("hits", len(searchHits)))
 	
-	result.Actions = make([]Action, 0, len(searchHits))
+	if len(searchHits) == 0 {
+		return result, nil
+	}
+
+	//hits are contiguous in global sequence order so account_action_seq
+	//of the oldest hit is the number of older actions of the account
+	//and the others follow it, unlike positions in the result it doesn't
+	//change when new actions arrive
+	oldest := searchHits[0]
+	if !ascOrder {
+		oldest = searchHits[len(searchHits) - 1]
+	}
+	var oldestTrace ActionTrace
+	if oldest.Source == nil || json.Unmarshal(*oldest.Source, &oldestTrace) != nil {
+		return nil, newElasticError(errors.New("Failed to parse ES response"))
+	}
+	firstSeq, err := countActionsBefore(ctx, client, params, indices[ActionTracesIndexPrefix], oldestTrace.Receipt.GlobalSequence)
+	if err != nil {
+		return nil, newElasticError(err)
+	}
+
+	actionTraces := make([]ActionTrace, len(searchHits))
 	for i, hit := range searchHits {
-		if hit.Source == nil {
-			continue
+		if hit.Source == nil || json.Unmarshal(*hit.Source, &actionTraces[i]) != nil {
+			return nil, newElasticError(errors.New("Failed to parse ES response"))
 		}
-
-		var accountActionSeq uint64
-		if ascOrder {
-			accountActionSeq = uint64(*params.Pos) + uint64(i)
-		} else {
-			accountActionSeq = totalActions - (uint64(*params.Pos) + uint64(i + 1))
+	}
+	//unique mode keeps positions of nodeos mode, so its page is shorter
+	//than requested when repeated traces are removed and that is reported
+	repeated := make([]bool, len(actionTraces))
+	if params.Mode == ActionsModeUnique {
+		repeated, err = repeatedActions(ctx, client, params, indices[ActionTracesIndexPrefix], actionTraces)
+		if err != nil {
+			return nil, newElasticError(err)
 		}
+		skipped := int64(0)
+		for _, r := range repeated {
+			if r {
+				skipped++
+			}
+		}
+		if skipped > 0 {
+			result.SkippedActions = &SkippedActions { Count: skipped,
+				Message: fmt.Sprintf("%d traces of actions that are returned at an earlier trace of the account were skipped, unique mode counts pos and offset over all traces like nodeos mode", skipped) }
+		}
+		span.SetAttributes(attribute.Int64("skipped", skipped))
+	}
 
-		var actionTrace ActionTrace
-		err = json.Unmarshal(*hit.Source, &actionTrace)
-		if err != nil {
+	result.Actions = make([]Action, 0, len(searchHits))
+	for i, actionTrace := range actionTraces {
+		if repeated[i] {
 			continue
 		}
-		trace, err := getActionTrace(client, actionTrace.TrxId, actionTrace.Receipt.GlobalSequence, indices)
+
+		accountActionSeq := uint64(firstSeq) + uint64(i)
+		if !ascOrder {
+			accountActionSeq = uint64(firstSeq) + uint64(len(searchHits) - 1 - i)
+		}
+
+		trace, traceContext, err := getActionTrace(ctx, client, actionTrace.TrxId, actionTrace.Receipt.GlobalSequence, indices)
 		if err != nil {
+			//stop if request was cancelled, otherwise skip the action
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			continue
 		}
 		action := Action { GlobalActionSeq: actionTrace.Receipt.GlobalSequence,
 			AccountActionSeq: accountActionSeq,
 			BlockNum: actionTrace.BlockNum, BlockTime: actionTrace.BlockTime,
 			ActionTrace: trace }
+		if params.TraceContext {
+			action.TraceContext = traceContext
+		}
 		result.Actions = append(result.Actions, action)
 	}
 	return result, nil
 }
 
 
-func getTransaction(client *elastic.Client, params GetTransactionParams, indices map[string][]string) (*GetTransactionResult, *ErrorWithCode) {
+func getTransaction(ctx context.Context, client *elastic.Client, params GetTransactionParams, indices map[string][]string) (_ *GetTransactionResult, err error) {
+	ctx, span := startSpan(ctx, "getTransaction",
+		attribute.String("trx_id", params.Id),
+		attribute.StringSlice("transactions_indices", indices[TransactionsIndexPrefix]),
+		attribute.StringSlice("transaction_traces_indices", indices[TransactionTracesIndexPrefix]))
+	defer func() { endSpan(span, err) }()
 	mgetTx := client.MultiGet()
 	mgetTxTrace := client.MultiGet()
 	for _, index := range indices[TransactionsIndexPrefix] {
@@ -331,19 +668,13 @@ func getTransaction(client *elastic.Client, params GetTransactionParams, indices
 	for _, index := range indices[TransactionTracesIndexPrefix] {
 		mgetTxTrace.Add(elastic.NewMultiGetItem().Index(index).Id(params.Id))
 	}
-	mgetTxResult, err := mgetTx.Do(context.Background())
+	mgetTxResult, err := mgetTx.Do(ctx)
 	if err != nil || mgetTxResult == nil || mgetTxResult.Docs == nil {
-		error := new(ErrorWithCode)
-		error.Error = err
-		error.Code = 500
-		return nil, error
+		return nil, newElasticError(err)
 	}
-	mgetTxTraceResult, err := mgetTxTrace.Do(context.Background())
+	mgetTxTraceResult, err := mgetTxTrace.Do(ctx)
 	if err != nil || mgetTxTraceResult == nil || mgetTxTraceResult.Docs == nil {
-		error := new(ErrorWithCode)
-		error.Error = err
-		error.Code = 500
-		return nil, error
+		return nil, newElasticError(err)
 	}
 
 	var getTxResult *elastic.GetResult
@@ -362,15 +693,12 @@ func getTransaction(client *elastic.Client, params GetTransactionParams, indices
 	}
 
 	if getTxTraceResult == nil || !getTxTraceResult.Found {
-		error := new(ErrorWithCode)
-		error.Error = errors.New("Transaction not found.")
-		error.Code = 404
-		return nil, error
+		return nil, newNotFoundError("Transaction not found")
 	}
 
-	result, error := createTransaction(getTxResult, getTxTraceResult)
-	if error != nil {
-		return nil, error
+	result, err := createTransaction(getTxResult, getTxTraceResult)
+	if err != nil {
+		return nil, err
 	}
 	result.Id = params.Id
 	return result, nil
@@ -379,29 +707,20 @@ func getTransaction(client *elastic.Client, params GetTransactionParams, indices
 
 //gets info from transactions and transaction_traces indices
 //and composes return value for get_transaction
-func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic.GetResult) (*GetTransactionResult, *ErrorWithCode) {
+func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic.GetResult) (*GetTransactionResult, error) {
 	//prepare data from transaction_traces index
 	var txTrace TransactionTrace
 	err := json.Unmarshal(*getTxTraceResult.Source, &txTrace)
 	if err != nil {
-		error := new(ErrorWithCode)
-		error.Error = err
-		error.Code = 500
-		return nil, error
+		return nil, newElasticError(err)
 	}
 	var status string
 	err = json.Unmarshal(txTrace.Receipt["status"], &status)
 	if err != nil {
-		error := new(ErrorWithCode)
-		error.Error = err
-		error.Code = 500
-		return nil, error
+		return nil, newElasticError(err)
 	}
 	if status == "hard_fail" {
-		error := new(ErrorWithCode)
-		error.Error = errors.New("Transaction not found")
-		error.Code = 404
-		return nil, error
+		return nil, newNotFoundError("Transaction not found")
 	}
 	result := new(GetTransactionResult)
 	result.Trx = make(map[string]json.RawMessage)
@@ -411,17 +730,11 @@ func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic
 	convertAbiToBytes(txTrace.ActionTraces)
 	result.Traces, err = json.Marshal(txTrace.ActionTraces)
 	if err != nil {
-		error := new(ErrorWithCode)
-		error.Error = err
-		error.Code = 500
-		return nil, error
+		return nil, newElasticError(err)
 	}
 	result.Trx["receipt"], err = json.Marshal(txTrace.Receipt)
 	if err != nil {
-		error := new(ErrorWithCode)
-		error.Error = err
-		error.Code = 500
-		return nil, error
+		return nil, newElasticError(err)
 	}
 	
 	//prepare data from transactions index
@@ -467,10 +780,7 @@ func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic
 			trx["context_free_data"] = transaction.ContextFreeData
 			byteTrx, err := json.Marshal(trx)
 			if err != nil {
-				error := new(ErrorWithCode)
-				error.Error = err
-				error.Code = 500
-				return nil, error
+				return nil, newElasticError(err)
 			}
 			result.Trx["trx"] = byteTrx
 		}
@@ -479,83 +789,408 @@ func createTransaction(getTxResult *elastic.GetResult, getTxTraceResult *elastic
 }
 
 
-func getKeyAccounts(client *elastic.Client, params GetKeyAccountsParams, indices map[string][]string) (*GetKeyAccountsResult, error) {
-	query := elastic.NewBoolQuery()
-	query = query.Filter(elastic.NewMatchQuery("pub_keys.key", params.PublicKey))
-	msearch := client.MultiSearch()
-	for _, index := range indices[AccountsIndexPrefix] {
-		msearch.Add(elastic.NewSearchRequest().Index(index).Query(query).Size(MaxQuerySize))
-	}
-	msearchResult, err := msearch.Do(context.Background())
-	if err != nil || msearchResult == nil || msearchResult.Responses == nil {
-		return nil, err
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
-	var searchHits []elastic.SearchHit
-	for _, resp := range msearchResult.Responses {
-		if resp == nil || resp.Error != nil {
-			continue
+	return false
+}
+
+
+func sortPermissionAccounts(permissions []PermissionAccount) {
+	sort.Slice(permissions, func(i, j int) bool {
+		if permissions[i].Account != permissions[j].Account {
+			return permissions[i].Account < permissions[j].Account
 		}
-		for _, hit := range resp.Hits.Hits {
-			if hit != nil {
-				searchHits = append(searchHits, *hit)
-			}
+		return permissions[i].Permission < permissions[j].Permission
+	})
+}
+
+
+//one page of distinct accounts matching the query
+type accountsPage struct {
+	Accounts  []Account
+	//approximate number of distinct accounts matching the query
+	//in any of their documents, upper bound of the accounts of all pages
+	Total     int64
+	Cursor    string
+	Truncated bool
+}
+
+
+//searches all accounts indices at once and returns a page of distinct accounts
+//sorted by name that goes after the cursor account
+//account present in several indices is taken from the latest one
+//even if only an older one matches the query
+func searchAccounts(ctx context.Context, client *elastic.Client, query elastic.Query, limit int, cursor string, indices map[string][]string) (page *accountsPage, err error) {
+	ctx, span := startSpan(ctx, "searchAccounts",
+		attribute.StringSlice("indices", indices[AccountsIndexPrefix]),
+		attribute.String("cursor", cursor))
+	defer func() {
+		if page != nil {
+			span.SetAttributes(attribute.Int("hits", len(page.Accounts)), attribute.Int64("total", page.Total))
+		}
+		endSpan(span, err)
+	}()
+	page = new(accountsPage)
+	page.Accounts = make([]Account, 0)
+	if len(indices[AccountsIndexPrefix]) == 0 {
+		return page, nil
+	}
+	if limit <= 0 || limit > MaxQuerySize {
+		limit = MaxQuerySize
+	}
+	//request one account more than limit to know if there is next page
+	names := elastic.NewCompositeAggregation().
+		Size(limit + 1).
+		Sources(elastic.NewCompositeAggregationTermsValuesSource("name").Field("name.keyword"))
+	if len(cursor) > 0 {
+		names = names.AggregateAfter(map[string]interface{} { "name": cursor })
+	}
+	total := elastic.NewCardinalityAggregation().Field("name.keyword").PrecisionThreshold(40000)
+	searchResult, err := client.Search(indices[AccountsIndexPrefix]...).
+		Query(query).
+		Size(0).
+		Aggregation("names", names).
+		Aggregation("total", total).
+		Do(ctx)
+	if err != nil {
+		return nil, newElasticError(err)
+	}
+	if searchResult == nil || searchResult.Aggregations == nil {
+		return page, nil
+	}
+	if count, found := searchResult.Aggregations.Cardinality("total"); found && count.Value != nil {
+		page.Total = int64(*count.Value)
+	}
+	composite, found := searchResult.Aggregations.Composite("names")
+	if !found {
+		return page, nil
+	}
+	accountNames := make([]interface{}, 0, len(composite.Buckets))
+	for _, bucket := range composite.Buckets {
+		if name, ok := bucket.Key["name"].(string); ok {
+			accountNames = append(accountNames, name)
 		}
 	}
+	if len(accountNames) > limit {
+		accountNames = accountNames[:limit]
+		page.Truncated = true
+		page.Cursor = accountNames[limit-1].(string)
+	}
+	if len(accountNames) == 0 {
+		return page, nil
+	}
 
-	result := new(GetKeyAccountsResult)
-	result.AccountNames = make([]string, 0, len(searchHits))
-	for _, hit := range searchHits {
-		if hit.Source == nil {
+	//the latest document of every account is taken without the query,
+	//otherwise an older document that still matches would hide the change,
+	//callers check the key or controller in the returned documents
+	docsQuery := elastic.NewBoolQuery()
+	docsQuery = docsQuery.Filter(elastic.NewTermsQuery("name.keyword", accountNames...))
+	docsResult, err := client.Search(indices[AccountsIndexPrefix]...).
+		Query(docsQuery).
+		Collapse(elastic.NewCollapseBuilder("name.keyword")).
+		Sort("_index", false).
+		Size(len(accountNames)).
+		Do(ctx)
+	if err != nil {
+		return nil, newElasticError(err)
+	}
+	if docsResult == nil || docsResult.Hits == nil {
+		return page, nil
+	}
+	for _, hit := range docsResult.Hits.Hits {
+		if hit == nil || hit.Source == nil {
 			continue
 		}
 		var account Account
 		err := json.Unmarshal(*hit.Source, &account)
 		if err != nil {
-			return nil, errors.New("Failed to parse ES response")
+			return nil, newElasticError(errors.New("Failed to parse ES response"))
+		}
+		page.Accounts = append(page.Accounts, account)
+	}
+	return page, nil
+}
+
+
+func getKeyAccounts(ctx context.Context, client *elastic.Client, params GetKeyAccountsParams, indices map[string][]string) (*GetKeyAccountsResult, error) {
+	//search all encodings of the key because ES contains keys in the format
+	//that was used by the node when the account was indexed
+	forms := []string { params.PublicKey }
+	if key, err := parsePublicKey(params.PublicKey); err == nil {
+		forms = key.Forms()
+	}
+	keyQuery := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+	for _, form := range forms {
+		keyQuery = keyQuery.Should(elastic.NewMatchQuery("pub_keys.key", form))
+	}
+	query := elastic.NewBoolQuery()
+	query = query.Filter(keyQuery)
+	if len(params.Permission) > 0 {
+		query = query.Filter(elastic.NewMatchQuery("pub_keys.permission", params.Permission))
+	}
+	page, err := searchAccounts(ctx, client, query, params.Limit, params.Cursor, indices)
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(GetKeyAccountsResult)
+	result.AccountNames = make([]string, 0, len(page.Accounts))
+	result.Total = page.Total
+	result.Cursor = page.Cursor
+	result.Truncated = page.Truncated
+	for _, account := range page.Accounts {
+		//pub_keys are not nested in ES so the key and permission
+		//of every entry are checked together here
+		found := false
+		for _, pubKey := range account.PubKeys {
+			if !containsString(forms, pubKey.Key) ||
+				(len(params.Permission) > 0 && pubKey.Permission != params.Permission) {
+				continue
+			}
+			found = true
+			if params.Extended {
+				result.Accounts = append(result.Accounts, PermissionAccount {
+					Account: account.Name, Permission: pubKey.Permission })
+			}
+		}
+		if found {
+			result.AccountNames = append(result.AccountNames, account.Name)
 		}
-		result.AccountNames = append(result.AccountNames, account.Name)
 	}
 	sort.Strings(result.AccountNames)
+	sortPermissionAccounts(result.Accounts)
 	return result, nil
 }
 
 
-func getControlledAccounts(client *elastic.Client, params GetControlledAccountsParams, indices map[string][]string) (*GetControlledAccountsResult, error) {
+func getControlledAccounts(ctx context.Context, client *elastic.Client, params GetControlledAccountsParams, indices map[string][]string) (*GetControlledAccountsResult, error) {
 	query := elastic.NewBoolQuery()
 	query = query.Filter(elastic.NewMatchQuery("account_controls.name.keyword", params.ControllingAccount))
+	if len(params.Permission) > 0 {
+		query = query.Filter(elastic.NewMatchQuery("account_controls.permission", params.Permission))
+	}
+	page, err := searchAccounts(ctx, client, query, params.Limit, params.Cursor, indices)
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(GetControlledAccountsResult)
+	result.ControlledAccounts = make([]string, 0, len(page.Accounts))
+	result.Total = page.Total
+	result.Cursor = page.Cursor
+	result.Truncated = page.Truncated
+	for _, account := range page.Accounts {
+		found := false
+		for _, control := range account.AccountControls {
+			if control.Name != params.ControllingAccount ||
+				(len(params.Permission) > 0 && control.Permission != params.Permission) {
+				continue
+			}
+			found = true
+			if params.Extended {
+				result.Accounts = append(result.Accounts, PermissionAccount {
+					Account: account.Name, Permission: control.Permission })
+			}
+		}
+		if found {
+			result.ControlledAccounts = append(result.ControlledAccounts, account.Name)
+		}
+	}
+	sort.Strings(result.ControlledAccounts)
+	sortPermissionAccounts(result.Accounts)
+	return result, nil
+}
+
+
+//groups keys and controlling accounts of the account by permission name
+func createAccountPermissions(account *Account) []AccountPermission {
+	permissions := make([]AccountPermission, 0)
+	positions := make(map[string]int)
+	permission := func(name string) *AccountPermission {
+		if i, ok := positions[name]; ok {
+			return &permissions[i]
+		}
+		positions[name] = len(permissions)
+		permissions = append(permissions, AccountPermission {
+			PermName: name, Keys: make([]string, 0), Accounts: make([]string, 0) })
+		return &permissions[len(permissions)-1]
+	}
+	for _, pubKey := range account.PubKeys {
+		p := permission(pubKey.Permission)
+		p.Keys = append(p.Keys, pubKey.Key)
+	}
+	for _, control := range account.AccountControls {
+		p := permission(control.Permission)
+		p.Accounts = append(p.Accounts, control.Name)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].PermName < permissions[j].PermName
+	})
+	return permissions
+}
+
+
+//searches action_traces indices for eosio::newaccount action
+//that created the requested account
+func getAccountCreation(ctx context.Context, client *elastic.Client, accountName string, indices map[string][]string) (_ *AccountCreation, err error) {
+	ctx, span := startSpan(ctx, "getAccountCreation",
+		attribute.String("account_name", accountName),
+		attribute.StringSlice("indices", indices[ActionTracesIndexPrefix]))
+	defer func() { endSpan(span, err) }()
+	if len(indices[ActionTracesIndexPrefix]) == 0 {
+		return nil, nil
+	}
+	query := elastic.NewBoolQuery()
+	query = query.Filter(elastic.NewMatchQuery("act.account", "eosio"))
+	query = query.Filter(elastic.NewMatchQuery("act.name", "newaccount"))
+	query = query.Filter(elastic.NewMatchQuery("act.data.name", accountName))
+	query = query.Filter(elastic.NewMatchQuery("receipt.receiver", "eosio"))
+	searchResult, err := client.Search(indices[ActionTracesIndexPrefix]...).
+		Query(query).
+		Sort("receipt.global_sequence", true).
+		Size(1).
+		Do(ctx)
+	if err != nil {
+		return nil, newElasticError(err)
+	}
+	if searchResult == nil || searchResult.Hits == nil || len(searchResult.Hits.Hits) == 0 ||
+		searchResult.Hits.Hits[0].Source == nil {
+		return nil, nil
+	}
+	var actionTrace ActionTrace
+	err = json.Unmarshal(*searchResult.Hits.Hits[0].Source, &actionTrace)
+	if err != nil {
+		return nil, newElasticError(errors.New("Failed to parse ES response"))
+	}
+	creation := AccountCreation { TrxId: actionTrace.TrxId,
+		GlobalActionSeq: actionTrace.Receipt.GlobalSequence,
+		BlockNum: actionTrace.BlockNum, BlockTime: actionTrace.BlockTime }
+	return &creation, nil
+}
+
+
+func getAccount(ctx context.Context, client *elastic.Client, params GetAccountParams, indices map[string][]string) (_ *GetAccountResult, err error) {
+	ctx, span := startSpan(ctx, "getAccount",
+		attribute.String("account_name", params.AccountName),
+		attribute.StringSlice("indices", indices[AccountsIndexPrefix]))
+	defer func() { endSpan(span, err) }()
+	query := elastic.NewBoolQuery()
+	query = query.Filter(elastic.NewMatchQuery("name.keyword", params.AccountName))
 	msearch := client.MultiSearch()
 	for _, index := range indices[AccountsIndexPrefix] {
-		msearch.Add(elastic.NewSearchRequest().Index(index).Query(query).Size(MaxQuerySize))
+		msearch.Add(elastic.NewSearchRequest().Index(index).Query(query).Size(1))
 	}
-	msearchResult, err := msearch.Do(context.Background())
+	msearchResult, err := msearch.Do(ctx)
 	if err != nil || msearchResult == nil || msearchResult.Responses == nil {
-		return nil, err
+		return nil, newElasticError(err)
 	}
-	var searchHits []elastic.SearchHit
-	for _, resp := range msearchResult.Responses {
-		if resp == nil || resp.Error != nil {
-			continue
+	if len(msearchResult.Responses) != len(indices[AccountsIndexPrefix]) {
+		return nil, newElasticError(fmt.Errorf("msearch returned %d responses for %d searches",
+			len(msearchResult.Responses), len(indices[AccountsIndexPrefix])))
+	}
+	//the latest index contains the most recent state of the account,
+	//failed search in any index fails the request
+	//so that stale state from older index is not returned
+	var hit *elastic.SearchHit
+	for i, resp := range msearchResult.Responses {
+		if resp == nil || resp.Error != nil || resp.Hits == nil {
+			return nil, newElasticError(msearchError(indices[AccountsIndexPrefix][i], resp))
 		}
-		for _, hit := range resp.Hits.Hits {
-			if hit != nil {
-				searchHits = append(searchHits, *hit)
+		for _, h := range resp.Hits.Hits {
+			if h != nil && h.Source != nil {
+				hit = h
 			}
 		}
 	}
+	if hit == nil {
+		return nil, newNotFoundError("Account not found")
+	}
 
-	result := new(GetControlledAccountsResult)
-	result.ControlledAccounts = make([]string, 0, len(searchHits))
-	for _, hit := range searchHits {
-		if hit.Source == nil {
-			continue
+	var account Account
+	err = json.Unmarshal(*hit.Source, &account)
+	if err != nil {
+		return nil, newElasticError(errors.New("Failed to parse ES response"))
+	}
+	result := new(GetAccountResult)
+	result.AccountName = account.Name
+	result.Creator = account.Creator
+	result.AccountCreateTime = account.AccountCreateTime
+	result.Permissions = createAccountPermissions(&account)
+	result.PubKeys = account.PubKeys
+	if result.PubKeys == nil {
+		result.PubKeys = make([]AccountPubKey, 0)
+	}
+	result.AccountControls = account.AccountControls
+	if result.AccountControls == nil {
+		result.AccountControls = make([]AccountControl, 0)
+	}
+	abi := strings.TrimSpace(string(account.Abi))
+	result.HasAbi = len(abi) > 0 && abi != "null" && abi != "\"\"" && abi != "{}"
+	//creation transaction is optional, account info is returned without it
+	result.CreationTrx, err = getAccountCreation(ctx, client, params.AccountName, indices)
+	if err != nil {
+		log.Printf("Creation transaction of account %s is not found: %s\n", params.AccountName, err)
+		err = nil
+	}
+	return result, nil
+}
+
+
+//returns number of documents and range of block numbers of every index
+func getIndicesStatus(ctx context.Context, client *elastic.Client, indices map[string][]string) (_ map[string][]IndexStatus, err error) {
+	ctx, span := startSpan(ctx, "getIndicesStatus")
+	defer func() { endSpan(span, err) }()
+	result := make(map[string][]IndexStatus)
+	prefixes := make([]string, 0, len(indices))
+	msearch := client.MultiSearch()
+	for prefix, prefixIndices := range indices {
+		prefixes = append(prefixes, prefix)
+		result[prefix] = make([]IndexStatus, 0, len(prefixIndices))
+	}
+	sort.Strings(prefixes)
+	requests := 0
+	for _, prefix := range prefixes {
+		for _, index := range indices[prefix] {
+			msearch.Add(elastic.NewSearchRequest().Index(index).Size(0).
+				Aggregation("min_block_num", elastic.NewMinAggregation().Field("block_num")).
+				Aggregation("max_block_num", elastic.NewMaxAggregation().Field("block_num")))
+			requests++
 		}
-		var account Account
-		err := json.Unmarshal(*hit.Source, &account)
-		if err != nil {
-			return nil, errors.New("Failed to parse ES response")
+	}
+	if requests == 0 {
+		return result, nil
+	}
+	msearchResult, err := msearch.Do(ctx)
+	if err != nil || msearchResult == nil || msearchResult.Responses == nil {
+		return nil, newElasticError(err)
+	}
+	i := 0
+	for _, prefix := range prefixes {
+		for _, index := range indices[prefix] {
+			status := IndexStatus { Index: index }
+			if i < len(msearchResult.Responses) {
+				resp := msearchResult.Responses[i]
+				if resp != nil && resp.Error == nil {
+					if resp.Hits != nil {
+						status.DocsCount = resp.Hits.TotalHits
+					}
+					if min, found := resp.Aggregations.Min("min_block_num"); found && min.Value != nil {
+						value := uint64(*min.Value)
+						status.MinBlockNum = &value
+					}
+					if max, found := resp.Aggregations.Max("max_block_num"); found && max.Value != nil {
+						value := uint64(*max.Value)
+						status.MaxBlockNum = &value
+					}
+				}
+			}
+			result[prefix] = append(result[prefix], status)
+			i++
 		}
-		result.ControlledAccounts = append(result.ControlledAccounts, account.Name)
 	}
-	sort.Strings(result.ControlledAccounts)
 	return result, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultElasticHealthcheckIntervalMs int64 = 60000
+const DefaultElasticHealthcheckTimeoutMs  int64 = 1000
+const DefaultElasticMaxRetries              int = 3
+const DefaultElasticRetryInitialBackoffMs int64 = 100
+const DefaultElasticRetryMaxBackoffMs     int64 = 5000
+
+
+//elasticUrls returns urls of Elasticsearch nodes from config
+//elastic_urls takes precedence over elastic_url
+func elasticUrls(config Config) []string {
+	if len(config.ElasticUrls) > 0 {
+		return config.ElasticUrls
+	}
+	return []string { config.ElasticUrl }
+}
+
+
+//newElasticClient creates olivere client for every node from config
+//with credentials, TLS settings, health checks and retry policy
+//requests are balanced between healthy nodes
+func newElasticClient(config Config) (*elastic.Client, error) {
+	httpClient, err := newElasticHttpClient(config)
+	if err != nil {
+		return nil, err
+	}
+	healthcheckInterval := milliseconds(config.ElasticHealthcheckIntervalMs, DefaultElasticHealthcheckIntervalMs)
+	healthcheckTimeout := milliseconds(config.ElasticHealthcheckTimeoutMs, DefaultElasticHealthcheckTimeoutMs)
+	return elastic.NewClient(
+		elastic.SetURL(elasticUrls(config)...),
+		elastic.SetSniff(false),
+		elastic.SetHttpClient(httpClient),
+		elastic.SetHealthcheck(!config.ElasticDisableHealthcheck),
+		elastic.SetHealthcheckInterval(healthcheckInterval),
+		elastic.SetHealthcheckTimeout(healthcheckTimeout),
+		elastic.SetHealthcheckTimeoutStartup(healthcheckTimeout),
+		elastic.SetRetrier(newElasticRetrier(config)))
+}
+
+
+//newElasticHttpClient returns http client for Elasticsearch requests
+//with credentials and TLS settings from config
+func newElasticHttpClient(config Config) (*http.Client, error) {
+	tlsConfig := &tls.Config { InsecureSkipVerify: config.ElasticInsecureSkipVerify }
+	if len(config.ElasticCaFile) > 0 {
+		pem, err := ioutil.ReadFile(config.ElasticCaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + config.ElasticCaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.ElasticCertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(config.ElasticCertFile, config.ElasticKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate { cert }
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	var base http.RoundTripper = transport
+	if len(config.ElasticUsername) > 0 || len(config.ElasticApiKey) > 0 {
+		base = &authTransport { Base: transport, Username: config.ElasticUsername,
+			Password: config.ElasticPassword, ApiKey: config.ElasticApiKey }
+	}
+	return &http.Client { Transport: instrumentElastic(base) }, nil
+}
+
+
+//authTransport is http.RoundTripper that adds
+//api key or basic auth credentials to every request
+type authTransport struct {
+	Base     http.RoundTripper
+	Username string
+	Password string
+	//base64 encoded id:api_key
+	ApiKey   string
+}
+
+func (t *authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	if len(t.ApiKey) > 0 {
+		r.Header.Set("Authorization", "ApiKey " + t.ApiKey)
+	} else {
+		r.SetBasicAuth(t.Username, t.Password)
+	}
+	return t.Base.RoundTrip(r)
+}
+
+
+//elasticRetrier retries requests that failed because of connection errors
+//with exponential backoff, the next attempt goes to the next healthy node
+//it gives up after max retries or when request context is done
+type elasticRetrier struct {
+	MaxRetries int
+	Backoff    elastic.Backoff
+}
+
+func newElasticRetrier(config Config) *elasticRetrier {
+	return &elasticRetrier { MaxRetries: config.ElasticMaxRetries,
+		Backoff: elastic.NewExponentialBackoff(
+			milliseconds(config.ElasticRetryInitialBackoffMs, DefaultElasticRetryInitialBackoffMs),
+			milliseconds(config.ElasticRetryMaxBackoffMs, DefaultElasticRetryMaxBackoffMs)) }
+}
+
+func (r *elasticRetrier) Retry(ctx context.Context, retry int, req *http.Request, resp *http.Response, err error) (time.Duration, bool, error) {
+	if ctx.Err() != nil {
+		return 0, false, ctx.Err()
+	}
+	if retry > r.MaxRetries {
+		return 0, false, nil
+	}
+	wait, ok := r.Backoff.Next(retry)
+	if deadline, hasDeadline := ctx.Deadline(); hasDeadline && time.Now().Add(wait).After(deadline) {
+		return 0, false, nil
+	}
+	return wait, ok, nil
+}
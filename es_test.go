@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/olivere/elastic"
+)
+
+
+//blocks from this one are in the second action_traces index
+const fakeSecondIndexBlock int64 = 1230
+
+
+//nodeosGetActions is get_actions request to nodeos history_plugin and its response
+type nodeosGetActions struct {
+	Request  GetActionsParams `json:"request"`
+	Response struct {
+		Actions []struct {
+			GlobalActionSeq  json.Number `json:"global_action_seq"`
+			AccountActionSeq      uint64 `json:"account_action_seq"`
+			BlockNum         json.Number `json:"block_num"`
+			ActionTrace struct {
+				Receipt struct {
+					ActDigest string `json:"act_digest"`
+				} `json:"receipt"`
+				TrxId         string `json:"trx_id"`
+			} `json:"action_trace"`
+		} `json:"actions"`
+	} `json:"response"`
+}
+
+//fakeElastic answers count, search, msearch and mget requests of get_actions
+//from transaction traces of the nodeos fixtures, action traces of blocks
+//before fakeSecondIndexBlock are in action_traces-1, others in action_traces-2
+type fakeElastic struct {
+	ActionTraces map[string][]map[string]interface{}
+	TxTraces     map[string]json.RawMessage
+	//search in this index returns error
+	FailIndex    string
+}
+
+func newFakeElastic(t *testing.T) *fakeElastic {
+	return newFakeElasticSplit(t, func(trace map[string]interface{}) bool {
+		return int64(trace["block_num"].(float64)) >= fakeSecondIndexBlock
+	})
+}
+
+//newFakeElasticSplit puts action traces for which secondIndex is true into action_traces-2
+func newFakeElasticSplit(t *testing.T, secondIndex func(trace map[string]interface{}) bool) *fakeElastic {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "nodeos", "transaction_traces.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var txTraces []json.RawMessage
+	if err := json.Unmarshal(data, &txTraces); err != nil {
+		t.Fatal(err)
+	}
+	es := &fakeElastic { ActionTraces: make(map[string][]map[string]interface{}),
+		TxTraces: make(map[string]json.RawMessage) }
+	for _, raw := range txTraces {
+		var txTrace struct {
+			Id           string                   `json:"id"`
+			ActionTraces []map[string]interface{} `json:"action_traces"`
+		}
+		if err := json.Unmarshal(raw, &txTrace); err != nil {
+			t.Fatal(err)
+		}
+		es.TxTraces[txTrace.Id] = raw
+		traces := txTrace.ActionTraces
+		for len(traces) > 0 {
+			trace := traces[0]
+			traces = traces[1:]
+			if inline, ok := trace["inline_traces"].([]interface{}); ok {
+				for _, child := range inline {
+					traces = append(traces, child.(map[string]interface{}))
+				}
+			}
+			index := "action_traces-1"
+			if secondIndex(trace) {
+				index = "action_traces-2"
+			}
+			es.ActionTraces[index] = append(es.ActionTraces[index], trace)
+		}
+	}
+	for _, docs := range es.ActionTraces {
+		sort.Slice(docs, func(i, j int) bool { return globalSequence(docs[i]) < globalSequence(docs[j]) })
+	}
+	return es
+}
+
+func globalSequence(trace map[string]interface{}) int64 {
+	return int64(trace["receipt"].(map[string]interface{})["global_sequence"].(float64))
+}
+
+//findValue returns the first value of the key in decoded json
+func findValue(v interface{}, key string) (interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if value, ok := v[key]; ok {
+			return value, true
+		}
+		for _, child := range v {
+			if value, ok := findValue(child, key); ok {
+				return value, true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if value, ok := findValue(child, key); ok {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+//match returns action traces of the index that match multi_match query
+//of the account and range of global sequence in the query
+func (es *fakeElastic) match(index string, query interface{}) []map[string]interface{} {
+	multiMatch, _ := findValue(query, "multi_match")
+	account, _ := findValue(multiMatch, "query")
+	lt := int64(-1)
+	if to, ok := findValue(query, "to"); ok && to != nil {
+		lt, _ = strconv.ParseInt(fmt.Sprint(to), 10, 64)
+	}
+	matched := make([]map[string]interface{}, 0)
+	for _, trace := range es.ActionTraces[index] {
+		if lt >= 0 && globalSequence(trace) >= lt {
+			continue
+		}
+		ok := trace["receipt"].(map[string]interface{})["receiver"] == account
+		for _, auth := range trace["act"].(map[string]interface{})["authorization"].([]interface{}) {
+			ok = ok || auth.(map[string]interface{})["actor"] == account
+		}
+		for _, field := range []string { "trx_id", "act_digest" } {
+			if values, found := findTerms(query, field); found {
+				ok = ok && containsValue(values, fieldValue(trace, field))
+			}
+		}
+		if ok {
+			matched = append(matched, trace)
+		}
+	}
+	return matched
+}
+
+//findTerms returns values of terms query of the field
+func findTerms(query interface{}, field string) ([]interface{}, bool) {
+	filters, _ := findValue(query, "filter")
+	list, _ := filters.([]interface{})
+	for _, filter := range list {
+		terms, _ := filter.(map[string]interface{})["terms"].(map[string]interface{})
+		for key, values := range terms {
+			if strings.HasSuffix(key, field) {
+				return values.([]interface{}), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func fieldValue(trace map[string]interface{}, field string) interface{} {
+	if field == "act_digest" {
+		return trace["receipt"].(map[string]interface{})["act_digest"]
+	}
+	return trace[field]
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//groups returns terms aggregation of traces by trx_id and act_digest
+//with the first trace of every group
+func groups(traces []map[string]interface{}) map[string]interface{} {
+	trxBuckets := make([]interface{}, 0)
+	digestBuckets := make(map[interface{}][]interface{})
+	for _, trace := range traces {
+		trxId, digest := trace["trx_id"], fieldValue(trace, "act_digest")
+		if _, ok := digestBuckets[trxId]; !ok {
+			trxBuckets = append(trxBuckets, trxId)
+		}
+		found := false
+		for _, bucket := range digestBuckets[trxId] {
+			found = found || bucket.(map[string]interface{})["key"] == digest
+		}
+		if !found {
+			digestBuckets[trxId] = append(digestBuckets[trxId], map[string]interface{} { "key": digest, "doc_count": 1,
+				"first": map[string]interface{} { "hits": map[string]interface{} { "total": 1,
+					"hits": []interface{} { map[string]interface{} { "_source": trace } } } } })
+		}
+	}
+	buckets := make([]interface{}, 0)
+	for _, trxId := range trxBuckets {
+		buckets = append(buckets, map[string]interface{} { "key": trxId, "doc_count": 1,
+			"digests": map[string]interface{} { "buckets": digestBuckets[trxId] } })
+	}
+	return map[string]interface{} { "trx_ids": map[string]interface{} { "buckets": buckets } }
+}
+
+func (es *fakeElastic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/_count"):
+		var body interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		count := 0
+		for _, index := range strings.Split(strings.Trim(strings.TrimSuffix(r.URL.Path, "/_count"), "/"), ",") {
+			count += len(es.match(index, body))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{} { "count": count })
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		var body interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		traces := make([]map[string]interface{}, 0)
+		for _, index := range strings.Split(strings.Trim(strings.TrimSuffix(r.URL.Path, "/_search"), "/"), ",") {
+			traces = append(traces, es.match(index, body)...)
+		}
+		sort.Slice(traces, func(i, j int) bool { return globalSequence(traces[i]) < globalSequence(traces[j]) })
+		json.NewEncoder(w).Encode(map[string]interface{} {
+			"hits": map[string]interface{} { "total": len(traces), "hits": []interface{} {} },
+			"aggregations": groups(traces) })
+	case r.URL.Path == "/_msearch":
+		responses := make([]interface{}, 0)
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(nil, 1 << 20)
+		for scanner.Scan() {
+			var header struct {
+				Index string `json:"index"`
+			}
+			var body struct {
+				From  int                          `json:"from"`
+				Size  int                          `json:"size"`
+				Query interface{}                  `json:"query"`
+				Sort  []map[string]map[string]string `json:"sort"`
+			}
+			json.Unmarshal(scanner.Bytes(), &header)
+			scanner.Scan()
+			json.Unmarshal(scanner.Bytes(), &body)
+			if header.Index == es.FailIndex {
+				responses = append(responses, map[string]interface{} { "status": 500,
+					"error": map[string]interface{} { "type": "search_phase_execution_exception", "reason": "all shards failed" } })
+				continue
+			}
+			traces := es.match(header.Index, body.Query)
+			if len(body.Sort) > 0 && body.Sort[0]["receipt.global_sequence"]["order"] == "desc" {
+				for i, j := 0, len(traces) - 1; i < j; i, j = i + 1, j - 1 {
+					traces[i], traces[j] = traces[j], traces[i]
+				}
+			}
+			hits := make([]interface{}, 0)
+			for i := body.From; i < body.From + body.Size && i < len(traces); i++ {
+				hits = append(hits, map[string]interface{} { "_index": header.Index, "_type": "_doc",
+					"_id": fmt.Sprint(globalSequence(traces[i])), "_source": traces[i] })
+			}
+			responses = append(responses, map[string]interface{} { "status": 200,
+				"hits": map[string]interface{} { "total": len(traces), "hits": hits } })
+		}
+		json.NewEncoder(w).Encode(map[string]interface{} { "responses": responses })
+	case r.URL.Path == "/_mget":
+		var body struct {
+			Docs []struct {
+				Index string `json:"_index"`
+				Id    string `json:"_id"`
+			} `json:"docs"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		docs := make([]interface{}, 0)
+		for _, doc := range body.Docs {
+			source, ok := es.TxTraces[doc.Id]
+			result := map[string]interface{} { "_index": doc.Index, "_type": "_doc", "_id": doc.Id, "found": ok }
+			if ok {
+				result["_source"] = source
+			}
+			docs = append(docs, result)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{} { "docs": docs })
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeElasticClient(t *testing.T, es *fakeElastic) *elastic.Client {
+	server := httptest.NewServer(es)
+	t.Cleanup(server.Close)
+	client, err := elastic.NewClient(elastic.SetURL(server.URL),
+		elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+var fakeIndices = map[string][]string {
+	ActionTracesIndexPrefix: { "action_traces-1", "action_traces-2" },
+	TransactionTracesIndexPrefix: { "transaction_traces-1" },
+}
+
+
+//account_action_seq of every page must be the same as nodeos returns
+//for the same history, it doesn't depend on the order and on indices of the page
+func TestGetActionsAccountActionSeq(t *testing.T) {
+	client := newFakeElasticClient(t, newFakeElastic(t))
+	tests := []struct {
+		name    string
+		fixture string
+	}{
+		{ "asc", "get_actions_asc.json" },
+		{ "desc", "get_actions_desc.json" },
+		{ "multi index", "get_actions_multi_index.json" },
+		{ "desc multi index", "get_actions_desc_multi_index.json" },
+		{ "whole history", "get_actions_all.json" },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "nodeos", test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected nodeosGetActions
+			if err := json.Unmarshal(data, &expected); err != nil {
+				t.Fatal(err)
+			}
+			result, err := getActions(context.Background(), client, expected.Request, fakeIndices, ActionsQueryLimits {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Actions) != len(expected.Response.Actions) {
+				t.Fatalf("got %d actions, nodeos returns %d", len(result.Actions), len(expected.Response.Actions))
+			}
+			//nodeos returns every page in ascending order
+			actions := result.Actions
+			sort.Slice(actions, func(i, j int) bool { return actions[i].AccountActionSeq < actions[j].AccountActionSeq })
+			for i, action := range actions {
+				want := expected.Response.Actions[i]
+				if string(action.GlobalActionSeq) != want.GlobalActionSeq.String() ||
+					action.AccountActionSeq != want.AccountActionSeq ||
+					string(action.BlockNum) != want.BlockNum.String() {
+					t.Errorf("action %d: got global_action_seq %s account_action_seq %d block_num %s, want %s %d %s",
+						i, action.GlobalActionSeq, action.AccountActionSeq, action.BlockNum,
+						want.GlobalActionSeq, want.AccountActionSeq, want.BlockNum)
+				}
+				if len(action.ActionTrace) == 0 {
+					t.Errorf("action %d: no action_trace", i)
+				}
+			}
+		})
+	}
+}
+
+func TestGetActionsFailedSearch(t *testing.T) {
+	es := newFakeElastic(t)
+	es.FailIndex = "action_traces-2"
+	client := newFakeElasticClient(t, es)
+	pos, offset := int64(8), int64(9)
+	params := GetActionsParams { AccountName: "alice", Pos: &pos, Offset: &offset }
+	result, err := getActions(context.Background(), client, params, fakeIndices, ActionsQueryLimits {})
+	if err == nil {
+		t.Fatalf("got %d actions, want error", len(result.Actions))
+	}
+	if e, ok := err.(*ApiError); !ok || e.Code != ElasticErrorCode {
+		t.Fatalf("got error %v, want elasticsearch error", err)
+	}
+}
+
+//failed search in the latest accounts index must not return the account from older index
+func TestGetAccountFailedSearch(t *testing.T) {
+	es := newFakeElastic(t)
+	es.FailIndex = "accounts-2"
+	client := newFakeElasticClient(t, es)
+	indices := map[string][]string { AccountsIndexPrefix: []string { "accounts-1", "accounts-2" } }
+	_, err := getAccount(context.Background(), client, GetAccountParams { AccountName: "alice" }, indices)
+	if e, ok := err.(*ApiError); !ok || e.Code != ElasticErrorCode {
+		t.Fatalf("got error %v, want elasticsearch error", err)
+	}
+}
+
+//unique mode returns every action at the first trace of the account
+//in its transaction, account_action_seq is still the nodeos one
+func TestGetActionsUnique(t *testing.T) {
+	client := newFakeElasticClient(t, newFakeElastic(t))
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "nodeos", "get_actions_all.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history nodeosGetActions
+	if err := json.Unmarshal(data, &history); err != nil {
+		t.Fatal(err)
+	}
+	firstTraces := make(map[string]uint64)
+	for _, action := range history.Response.Actions {
+		key := action.ActionTrace.TrxId + "/" + action.ActionTrace.Receipt.ActDigest
+		if _, ok := firstTraces[key]; !ok {
+			firstTraces[key] = action.AccountActionSeq
+		}
+	}
+	tests := []struct {
+		name   string
+		pos    int64
+		offset int64
+		//range of account_action_seq of the page
+		first  uint64
+		last   uint64
+	}{
+		{ "asc", 0, 9, 0, 9 },
+		{ "desc", -1, -10, 16, 25 },
+		{ "multi index", 8, 9, 8, 17 },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pos, offset := test.pos, test.offset
+			params := GetActionsParams { AccountName: "alice", Pos: &pos, Offset: &offset, Mode: ActionsModeUnique }
+			result, err := getActions(context.Background(), client, params, fakeIndices, ActionsQueryLimits {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := make([]uint64, 0)
+			for _, action := range history.Response.Actions {
+				key := action.ActionTrace.TrxId + "/" + action.ActionTrace.Receipt.ActDigest
+				if action.AccountActionSeq >= test.first && action.AccountActionSeq <= test.last &&
+					firstTraces[key] == action.AccountActionSeq {
+					expected = append(expected, action.AccountActionSeq)
+				}
+			}
+			got := make([]uint64, 0, len(result.Actions))
+			for _, action := range result.Actions {
+				got = append(got, action.AccountActionSeq)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if fmt.Sprint(got) != fmt.Sprint(expected) {
+				t.Fatalf("got account_action_seq %v, want %v", got, expected)
+			}
+			skipped := int64(test.last - test.first + 1) - int64(len(expected))
+			if (skipped == 0 && result.SkippedActions != nil) ||
+				(skipped > 0 && (result.SkippedActions == nil || result.SkippedActions.Count != skipped)) {
+				t.Errorf("got skipped_actions %+v, want %d", result.SkippedActions, skipped)
+			}
+		})
+	}
+}
+
+//the first trace of an action can be in an older index than the page
+func TestGetActionsUniqueSplitAction(t *testing.T) {
+	//5001 is the transfer of alice in eosio.token, 5002 and 5003 are its notifications
+	es := newFakeElasticSplit(t, func(trace map[string]interface{}) bool {
+		return globalSequence(trace) >= 5003
+	})
+	client := newFakeElasticClient(t, es)
+	//the page is account_action_seq 2 to 25, all of them in action_traces-2
+	pos, offset := int64(-1), int64(-24)
+	params := GetActionsParams { AccountName: "alice", Pos: &pos, Offset: &offset, Mode: ActionsModeUnique }
+	result, err := getActions(context.Background(), client, params, fakeIndices, ActionsQueryLimits {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range result.Actions {
+		if action.AccountActionSeq == 2 {
+			t.Errorf("got notification 5003 of the transfer returned at 5001")
+		}
+	}
+}
+
+//clamped page keeps the actions next to pos for both signs of offset
+func TestGetActionsClampedPage(t *testing.T) {
+	client := newFakeElasticClient(t, newFakeElastic(t))
+	limits := ActionsQueryLimits { MaxPageSize: 4, Clamp: true }
+	tests := []struct {
+		name   string
+		pos    int64
+		offset int64
+		want   string
+	}{
+		{ "from pos", 10, 9, "[10 11 12 13]" },
+		{ "to pos", 20, -10, "[17 18 19 20]" },
+		{ "from the end", -1, -10, "[22 23 24 25]" },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pos, offset := test.pos, test.offset
+			params := GetActionsParams { AccountName: "alice", Pos: &pos, Offset: &offset }
+			result, err := getActions(context.Background(), client, params, fakeIndices, limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]uint64, 0, len(result.Actions))
+			for _, action := range result.Actions {
+				got = append(got, action.AccountActionSeq)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if fmt.Sprint(got) != test.want {
+				t.Errorf("got account_action_seq %v, want %s", got, test.want)
+			}
+			if result.QueryLimit == nil || result.QueryLimit.Limit != "actions_max_page_size" {
+				t.Errorf("got query_limit %+v, want actions_max_page_size", result.QueryLimit)
+			}
+		})
+	}
+}
@@ -93,14 +93,23 @@ type TransactionTrace struct {
 }
 
 
+type AccountPubKey struct {
+	Permission string `json:"permission"`
+	Key        string `json:"key"`
+}
+
+
+type AccountControl struct {
+	Name       string `json:"name"`
+	Permission string `json:"permission"`
+}
+
+
 type Account struct {
 	Name             string `json:"name"`
 	Creator json.RawMessage `json:"creator"`
-	PubKeys json.RawMessage `json:"pub_keys"`
-	AccountControls [] struct {
-		Name       json.RawMessage `json:"name"`
-		Permission json.RawMessage `json:"permission"`
-	} `json:"account_controls"`
+	PubKeys  []AccountPubKey `json:"pub_keys"`
+	AccountControls []AccountControl `json:"account_controls"`
 	Abi               json.RawMessage `json:"abi"`
 	AccountCreateTime json.RawMessage `json:"account_create_time"`
 }
\ No newline at end of file
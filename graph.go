@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/olivere/elastic"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+
+const DefaultGraphDepth int = 3
+const MaxGraphDepth     int = 10
+const MaxGraphNodes     int = 1000
+
+const GraphFormatJson string = "json"
+const GraphFormatDot  string = "dot"
+
+
+//walks account_controls level by level starting from the controlling account
+//and collects every account controlled by it directly or transitively
+//up to the requested depth
+func getControlledAccountsGraph(ctx context.Context, client *elastic.Client, params GetControlledAccountsGraphParams, indices map[string][]string) (result *GetControlledAccountsGraphResult, err error) {
+	depth := DefaultGraphDepth
+	if params.Depth != nil {
+		depth = *params.Depth
+	}
+	ctx, span := startSpan(ctx, "getControlledAccountsGraph",
+		attribute.String("account_name", params.ControllingAccount),
+		attribute.Int("depth", depth))
+	defer func() {
+		if result != nil {
+			span.SetAttributes(attribute.Int("nodes", len(result.Nodes)), attribute.Int("edges", len(result.Edges)))
+		}
+		endSpan(span, err)
+	}()
+	result = new(GetControlledAccountsGraphResult)
+	result.Root = params.ControllingAccount
+	result.Nodes = []GraphNode { GraphNode { Account: params.ControllingAccount, Depth: 0 } }
+	result.Edges = make([]GraphEdge, 0)
+
+	visited := map[string]bool { params.ControllingAccount: true }
+	frontier := []string { params.ControllingAccount }
+	for level := 1; level <= depth && len(frontier) > 0; level++ {
+		next := make([]string, 0)
+		for _, controller := range frontier {
+			controlled, err := getControlledAccounts(ctx, client, GetControlledAccountsParams {
+				ControllingAccount: controller, Extended: true, Limit: MaxQuerySize }, indices)
+			if err != nil {
+				return nil, err
+			}
+			if controlled.Truncated {
+				result.Truncated = true
+			}
+			for _, permission := range controlled.Accounts {
+				if !visited[permission.Account] && len(result.Nodes) >= MaxGraphNodes {
+					result.Truncated = true
+					continue
+				}
+				result.Edges = append(result.Edges, GraphEdge {
+					From: controller, To: permission.Account, Permission: permission.Permission })
+				if visited[permission.Account] {
+					continue
+				}
+				visited[permission.Account] = true
+				result.Nodes = append(result.Nodes, GraphNode { Account: permission.Account, Depth: level })
+				next = append(next, permission.Account)
+			}
+		}
+		frontier = next
+	}
+	markCycles(result)
+	return result, nil
+}
+
+
+//marks edges that close a cycle
+//graph is walked depth first from the root in order of edges
+//and edge closes a cycle if it leads back to an account on the current path,
+//other edges of the cycle are not marked
+func markCycles(graph *GetControlledAccountsGraphResult) {
+	outgoing := make(map[string][]int)
+	for i, edge := range graph.Edges {
+		outgoing[edge.From] = append(outgoing[edge.From], i)
+	}
+	onPath := make(map[string]bool)
+	visited := make(map[string]bool)
+	var walk func(account string)
+	walk = func(account string) {
+		visited[account] = true
+		onPath[account] = true
+		for _, i := range outgoing[account] {
+			to := graph.Edges[i].To
+			if onPath[to] {
+				graph.Edges[i].Cycle = true
+				graph.HasCycles = true
+			} else if !visited[to] {
+				walk(to)
+			}
+		}
+		onPath[account] = false
+	}
+	walk(graph.Root)
+}
+
+
+//encodes the graph in Graphviz DOT format
+//edges that close a cycle are drawn red
+func graphToDot(graph *GetControlledAccountsGraphResult) []byte {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "digraph %q {\n", graph.Root)
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(b, "\t%q [label=%q];\n", node.Account, fmt.Sprintf("%s (%d)", node.Account, node.Depth))
+	}
+	for _, edge := range graph.Edges {
+		if edge.Cycle {
+			fmt.Fprintf(b, "\t%q -> %q [label=%q, color=red];\n", edge.From, edge.To, edge.Permission)
+		} else {
+			fmt.Fprintf(b, "\t%q -> %q [label=%q];\n", edge.From, edge.To, edge.Permission)
+		}
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
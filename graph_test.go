@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+
+func TestMarkCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		//edges as from>to
+		edges  []string
+		//edges marked as closing a cycle
+		want   string
+	}{
+		{ "tree", []string { "root>a", "root>b", "a>c" }, "[]" },
+		{ "shared child", []string { "root>a", "root>b", "a>c", "b>c" }, "[]" },
+		{ "self", []string { "root>a", "a>a" }, "[a>a]" },
+		{ "to root", []string { "root>a", "a>b", "b>root" }, "[b>root]" },
+		//only the edge back to a is marked, not a>b and b>c
+		{ "inner", []string { "root>a", "a>b", "b>c", "c>a", "root>c" }, "[c>a]" },
+		{ "two cycles", []string { "root>a", "a>root", "root>b", "b>c", "c>b" }, "[a>root c>b]" },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			graph := &GetControlledAccountsGraphResult { Root: "root" }
+			for _, edge := range test.edges {
+				accounts := strings.SplitN(edge, ">", 2)
+				graph.Edges = append(graph.Edges, GraphEdge { From: accounts[0], To: accounts[1] })
+			}
+			markCycles(graph)
+			got := make([]string, 0)
+			for _, edge := range graph.Edges {
+				if edge.Cycle {
+					got = append(got, edge.From + ">" + edge.To)
+				}
+			}
+			if fmt.Sprint(got) != test.want {
+				t.Errorf("got cycle edges %v, want %s", got, test.want)
+			}
+			if graph.HasCycles != (test.want != "[]") {
+				t.Errorf("got has_cycles %v", graph.HasCycles)
+			}
+		})
+	}
+}
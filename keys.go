@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+	"golang.org/x/crypto/ripemd160"
+)
+
+
+const LegacyPublicKeyPrefix string = "EOS"
+const PublicKeyPrefix       string = "PUB_"
+const KeyTypeK1             string = "K1"
+const KeyTypeR1             string = "R1"
+const KeyTypeWA             string = "WA"
+
+const Base58Alphabet string = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+const compressedKeySize int = 33
+const checksumSize      int = 4
+
+
+type PublicKey struct {
+	Type string
+	Data []byte
+}
+
+
+func base58Decode(s string) ([]byte, error) {
+	result := big.NewInt(0)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(Base58Alphabet, c)
+		if i < 0 {
+			return nil, errors.New("invalid base58 character '" + string(c) + "'")
+		}
+		result.Mul(result, radix)
+		result.Add(result, big.NewInt(int64(i)))
+	}
+	decoded := result.Bytes()
+	//every leading '1' encodes a leading zero byte
+	zeros := 0
+	for zeros < len(s) && s[zeros] == Base58Alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), decoded...), nil
+}
+
+
+func base58Encode(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var result []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, radix, mod)
+		result = append(result, Base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		result = append(result, Base58Alphabet[0])
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return string(result)
+}
+
+
+//returns first 4 bytes of ripemd160 of the key data followed by the suffix
+//legacy keys are checksummed without suffix
+func keyChecksum(data []byte, suffix string) []byte {
+	hasher := ripemd160.New()
+	hasher.Write(data)
+	hasher.Write([]byte(suffix))
+	return hasher.Sum(nil)[:checksumSize]
+}
+
+
+//parses public key in legacy EOS... format or in PUB_<type>_... format
+//and validates its checksum
+func parsePublicKey(s string) (*PublicKey, error) {
+	var keyType string
+	var encoded string
+	var suffix string
+	if strings.HasPrefix(s, PublicKeyPrefix) {
+		parts := strings.SplitN(s[len(PublicKeyPrefix):], "_", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("public key has no key type")
+		}
+		keyType = parts[0]
+		encoded = parts[1]
+		suffix = keyType
+		if keyType != KeyTypeK1 && keyType != KeyTypeR1 && keyType != KeyTypeWA {
+			return nil, errors.New("unsupported public key type " + keyType)
+		}
+	} else if strings.HasPrefix(s, LegacyPublicKeyPrefix) {
+		keyType = KeyTypeK1
+		encoded = s[len(LegacyPublicKeyPrefix):]
+	} else {
+		return nil, errors.New("public key must start with " + LegacyPublicKeyPrefix + " or " + PublicKeyPrefix)
+	}
+
+	decoded, err := base58Decode(encoded)
+	if err != nil {
+		return nil, errors.New("public key is not base58 encoded: " + err.Error())
+	}
+	if len(decoded) <= checksumSize {
+		return nil, errors.New("public key is too short")
+	}
+	data := decoded[:len(decoded)-checksumSize]
+	checksum := decoded[len(decoded)-checksumSize:]
+	if !bytes.Equal(checksum, keyChecksum(data, suffix)) {
+		return nil, errors.New("public key checksum mismatch")
+	}
+	//WebAuthn keys carry additional data after the compressed key
+	if (keyType == KeyTypeWA && len(data) <= compressedKeySize) ||
+		(keyType != KeyTypeWA && len(data) != compressedKeySize) {
+		return nil, errors.New("public key has invalid length")
+	}
+	if data[0] != 0x02 && data[0] != 0x03 {
+		return nil, errors.New("public key is not a compressed point")
+	}
+	return &PublicKey { Type: keyType, Data: data }, nil
+}
+
+
+//returns key in PUB_<type>_... format
+func (k *PublicKey) String() string {
+	return PublicKeyPrefix + k.Type + "_" + base58Encode(append(append([]byte{}, k.Data...), keyChecksum(k.Data, k.Type)...))
+}
+
+
+//returns key in legacy EOS... format, only K1 keys have legacy format
+func (k *PublicKey) LegacyString() string {
+	if k.Type != KeyTypeK1 {
+		return ""
+	}
+	return LegacyPublicKeyPrefix + base58Encode(append(append([]byte{}, k.Data...), keyChecksum(k.Data, "")...))
+}
+
+
+//returns all encodings of the key that can be stored in ES
+func (k *PublicKey) Forms() []string {
+	forms := []string { k.String() }
+	if legacy := k.LegacyString(); len(legacy) > 0 {
+		forms = append(forms, legacy)
+	}
+	return forms
+}
+
+
+//compares two public keys regardless of their encoding
+//keys that can't be parsed are compared as strings
+func samePublicKey(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	keyA, err := parsePublicKey(a)
+	if err != nil {
+		return false
+	}
+	keyB, err := parsePublicKey(b)
+	if err != nil {
+		return false
+	}
+	return keyA.Type == keyB.Type && bytes.Equal(keyA.Data, keyB.Data)
+}
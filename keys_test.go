@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+
+const testLegacyKey string = "EOS6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5GDW5CV"
+const testK1Key     string = "PUB_K1_6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5BoDq63"
+const testR1Key     string = "PUB_R1_6FPFZqw5ahYrR9jD96yDbbDNTdKtNqRbze6oTDLntrsANgQKZu"
+
+
+func TestParsePublicKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		//forms of the parsed key, empty if parsing fails
+		forms  []string
+		//part of the error message
+		err    string
+	}{
+		{ "legacy", testLegacyKey, []string { testK1Key, testLegacyKey }, "" },
+		{ "k1", testK1Key, []string { testK1Key, testLegacyKey }, "" },
+		{ "r1", testR1Key, []string { testR1Key }, "" },
+		{ "legacy changed last character", "EOS6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5GDW5CW", nil, "checksum mismatch" },
+		{ "legacy changed first character", "EOS7MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5GDW5CV", nil, "checksum mismatch" },
+		{ "k1 changed character", "PUB_K1_6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqEt5BoDq63", nil, "checksum mismatch" },
+		{ "r1 changed character", "PUB_R1_6FPFZqw5ahYrR9jD96yDbbDNTdKtNqRbze6oTDLntrsANgQKZv", nil, "checksum mismatch" },
+		//the same data with checksum of the other key type
+		{ "k1 data with r1 type", "PUB_R1_6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5BoDq63", nil, "checksum mismatch" },
+		{ "legacy checksum in k1 format", "PUB_K1_6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5GDW5CV", nil, "checksum mismatch" },
+		{ "empty", "", nil, "must start with" },
+		{ "unknown prefix", "FIO6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5GDW5CV", nil, "must start with" },
+		{ "no key type", "PUB_6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5BoDq63", nil, "no key type" },
+		{ "unsupported key type", "PUB_K2_6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5BoDq63", nil, "unsupported public key type" },
+		{ "invalid base58", "EOS6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5GDW50V", nil, "not base58" },
+		{ "too short", "EOS1111", nil, "too short" },
+		{ "truncated", testLegacyKey[:len(testLegacyKey) - 1], nil, "checksum mismatch" },
+		{ "prefix only", "PUB_K1_", nil, "too short" },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := parsePublicKey(test.key)
+			if len(test.err) > 0 {
+				if err == nil {
+					t.Fatalf("parsed %s as %s, want error %q", test.key, key, test.err)
+				}
+				if !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %q, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			forms := key.Forms()
+			if strings.Join(forms, " ") != strings.Join(test.forms, " ") {
+				t.Fatalf("got forms %v, want %v", forms, test.forms)
+			}
+		})
+	}
+}
+
+func TestSamePublicKey(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		same bool
+	}{
+		{ testLegacyKey, testK1Key, true },
+		{ testK1Key, testLegacyKey, true },
+		{ testLegacyKey, testLegacyKey, true },
+		{ testR1Key, testR1Key, true },
+		{ testK1Key, testR1Key, false },
+		//the same data as K1 key but R1 type is a different key
+		{ testK1Key, "PUB_R1_6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5Bpuyty", false },
+		{ testLegacyKey, "EOS6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5GDW5CW", false },
+		{ "invalid", "invalid", true },
+		{ "invalid", testLegacyKey, false },
+	}
+	for _, test := range tests {
+		if same := samePublicKey(test.a, test.b); same != test.same {
+			t.Errorf("samePublicKey(%s, %s) = %v, want %v", test.a, test.b, same, test.same)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+)
+
+
+const ActionsLimitModeReject string = "reject"
+const ActionsLimitModeClamp  string = "clamp"
+//default max_result_window of Elasticsearch indices
+const DefaultActionsMaxWindow int64 = 10000
+//allows offset from -1000 to 1000
+const DefaultActionsMaxPageSize int64 = 1001
+const DefaultActionsCountConcurrency int = 8
+
+
+//ActionsQueryLimits are maximums of the cost of get_actions query
+//0 means that the value is not limited
+type ActionsQueryLimits struct {
+	//number of actions in one page
+	MaxPageSize int64
+	//number of action_traces indices searched for one page
+	MaxIndices int
+	//from + size of the search in one index
+	MaxWindow int64
+	//cut the query down to the limits instead of rejecting it
+	Clamp bool
+	//number of indices counted at the same time
+	CountConcurrency int
+}
+
+func newActionsQueryLimits(config Config) ActionsQueryLimits {
+	return ActionsQueryLimits { MaxPageSize: config.ActionsMaxPageSize,
+		MaxIndices: config.ActionsMaxIndices, MaxWindow: config.ActionsMaxWindow,
+		Clamp: config.ActionsLimitMode == ActionsLimitModeClamp,
+		CountConcurrency: config.ActionsCountConcurrency }
+}
+
+
+//actionsSearch is the search of a part of the page in one index
+type actionsSearch struct {
+	Index string
+	//number of actions of the account in the index
+	Count int64
+	From  int64
+	Size  int64
+}
+
+//checkPageSize returns start and size of the page allowed by the limits
+//and the applied limit if the page size was reduced
+//page that ends at the requested pos (negative offset) keeps its end when it is clamped,
+//so it still has the actions closest to pos
+func (l ActionsQueryLimits) checkPageSize(start int64, size int64, endsAtPos bool) (_ int64, _ int64, applied *QueryLimit, err error) {
+	if l.MaxPageSize <= 0 || size <= l.MaxPageSize {
+		return start, size, nil, nil
+	}
+	message := fmt.Sprintf("Page of %d actions exceeds the limit of %d actions, use smaller offset", size, l.MaxPageSize)
+	if !l.Clamp {
+		return 0, 0, nil, newQueryLimitError(message)
+	}
+	if endsAtPos {
+		start += size - l.MaxPageSize
+	}
+	return start, l.MaxPageSize, &QueryLimit { Limit: "actions_max_page_size", Value: l.MaxPageSize, Message: message }, nil
+}
+
+//checkSearches checks searches of one page against the limits
+//and returns searches that can be sent to Elasticsearch
+//in clamp mode the page is cut at the first search over the limits
+//so that returned actions are still contiguous
+func (l ActionsQueryLimits) checkSearches(searches []actionsSearch) (_ []actionsSearch, applied *QueryLimit, err error) {
+	if l.MaxIndices > 0 && len(searches) > l.MaxIndices {
+		message := fmt.Sprintf("Requested actions are spread over %d indices, the limit is %d indices, use smaller offset",
+			len(searches), l.MaxIndices)
+		if !l.Clamp {
+			return nil, nil, newQueryLimitError(message)
+		}
+		searches = searches[:l.MaxIndices]
+		applied = &QueryLimit { Limit: "actions_max_indices", Value: int64(l.MaxIndices), Message: message }
+	}
+	if l.MaxWindow <= 0 {
+		return searches, applied, nil
+	}
+	for i, search := range searches {
+		if search.From + search.Size <= l.MaxWindow {
+			continue
+		}
+		message := fmt.Sprintf("Requested actions are at positions %d to %d of index %s, only the first %d actions of an index can be searched, use pos closer to the start or the end of the history",
+			search.From, search.From + search.Size - 1, search.Index, l.MaxWindow)
+		//actions beyond the window can't be reached by from and size at all
+		if !l.Clamp || search.From >= l.MaxWindow {
+			return nil, nil, newQueryLimitError(message)
+		}
+		searches[i].Size = l.MaxWindow - search.From
+		return searches[:i + 1], &QueryLimit { Limit: "actions_max_window", Value: l.MaxWindow, Message: message }, nil
+	}
+	return searches, applied, nil
+}
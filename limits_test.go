@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+
+func TestCheckPageSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    ActionsQueryLimits
+		start     int64
+		size      int64
+		endsAtPos bool
+		//expected page, limit is empty if the page is not clamped
+		wantStart int64
+		wantSize  int64
+		limit     string
+		err       bool
+	}{
+		{ "not limited", ActionsQueryLimits {}, 0, 100000, false, 0, 100000, "", false },
+		{ "under limit", ActionsQueryLimits { MaxPageSize: 1001 }, 10, 1001, false, 10, 1001, "", false },
+		{ "reject", ActionsQueryLimits { MaxPageSize: 1001 }, 10, 1002, false, 0, 0, "", true },
+		{ "clamp from pos", ActionsQueryLimits { MaxPageSize: 1001, Clamp: true }, 100000, 5001, false, 100000, 1001, "actions_max_page_size", false },
+		//pos=100000 offset=-5000 is the page from 95000 to 100000,
+		//the clamped page has the actions next to pos
+		{ "clamp to pos", ActionsQueryLimits { MaxPageSize: 1001, Clamp: true }, 95000, 5001, true, 99000, 1001, "actions_max_page_size", false },
+		{ "reject to pos", ActionsQueryLimits { MaxPageSize: 1001 }, 95000, 5001, true, 0, 0, "", true },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, size, applied, err := test.limits.checkPageSize(test.start, test.size, test.endsAtPos)
+			if test.err {
+				if e, ok := err.(*ApiError); !ok || e.Code != QueryLimitErrorCode {
+					t.Fatalf("got error %v, want query limit error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != test.wantStart || size != test.wantSize {
+				t.Errorf("got page from %d of %d actions, want from %d of %d actions", start, size, test.wantStart, test.wantSize)
+			}
+			if (applied == nil && len(test.limit) > 0) || (applied != nil && applied.Limit != test.limit) {
+				t.Errorf("got applied limit %+v, want %q", applied, test.limit)
+			}
+		})
+	}
+}
+
+func TestCheckSearches(t *testing.T) {
+	searches := func() []actionsSearch {
+		return []actionsSearch {
+			{ Index: "action_traces-1", Count: 20000, From: 9000, Size: 11000 },
+			{ Index: "action_traces-2", Count: 20000, From: 0, Size: 20000 },
+			{ Index: "action_traces-3", Count: 20000, From: 0, Size: 500 },
+		}
+	}
+	tests := []struct {
+		name   string
+		limits ActionsQueryLimits
+		input  []actionsSearch
+		//from and size of returned searches
+		want   string
+		limit  string
+		err    bool
+	}{
+		{ "not limited", ActionsQueryLimits {}, searches(), "[{9000 11000} {0 20000} {0 500}]", "", false },
+		{ "reject indices", ActionsQueryLimits { MaxIndices: 2 }, searches(), "", "", true },
+		{ "clamp indices", ActionsQueryLimits { MaxIndices: 2, Clamp: true }, searches(), "[{9000 11000} {0 20000}]", "actions_max_indices", false },
+		{ "reject window", ActionsQueryLimits { MaxWindow: 10000 }, searches(), "", "", true },
+		//the page is cut at the first search over the window so that it stays contiguous
+		{ "clamp window", ActionsQueryLimits { MaxWindow: 10000, Clamp: true }, searches(), "[{9000 1000}]", "actions_max_window", false },
+		{ "beyond window", ActionsQueryLimits { MaxWindow: 9000, Clamp: true }, searches(), "", "", true },
+		{ "under limits", ActionsQueryLimits { MaxIndices: 3, MaxWindow: 20000 }, searches(), "[{9000 11000} {0 20000} {0 500}]", "", false },
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, applied, err := test.limits.checkSearches(test.input)
+			if test.err {
+				if e, ok := err.(*ApiError); !ok || e.Code != QueryLimitErrorCode {
+					t.Fatalf("got error %v, want query limit error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(result))
+			for _, search := range result {
+				got = append(got, fmt.Sprintf("{%d %d}", search.From, search.Size))
+			}
+			if fmt.Sprint(got) != test.want {
+				t.Errorf("got searches %v, want %s", got, test.want)
+			}
+			if (applied == nil && len(test.limit) > 0) || (applied != nil && applied.Limit != test.limit) {
+				t.Errorf("got applied limit %+v, want %q", applied, test.limit)
+			}
+		})
+	}
+}
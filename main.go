@@ -3,28 +3,88 @@ package main
 import (
 	"os"
 	"fmt"
+	"log"
+	"flag"
+	"syscall"
+	"os/signal"
 	"encoding/json"
 )
 
-const ConfigFilename string = "config.json"
-
 
 func main() {
-	var config Config
-	file, err := os.Open(ConfigFilename)
+	//config file can be given by flag or by environment variable for docker
+	defaultPath := ConfigFilename
+	if path, ok := os.LookupEnv(ConfigEnvFile); ok && len(path) > 0 {
+		defaultPath = path
+	}
+	configPath := flag.String("config", defaultPath, "path to json or yaml config file")
+	printConfig := flag.Bool("print-config", false, "print effective config and exit")
+	flag.Parse()
+	explicit := defaultPath != ConfigFilename
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+
+	config, err := loadConfig(*configPath, explicit)
 	if err != nil {
-		fmt.Printf("Failed to open %s\n", ConfigFilename)
-		return
+		fmt.Fprintf(os.Stderr, "Failed to load config: %s\n", err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		b, _ := json.MarshalIndent(config.masked(), "", "    ")
+		fmt.Println(string(b))
 	}
-	decoder := json.NewDecoder(file) 
-	err = decoder.Decode(&config) 
+	err = config.validate()
 	if err != nil {
-		fmt.Printf("Failed decode config\n")
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if *printConfig {
 		return
 	}
 
+	shutdownTracing := initTracing(config)
 	server := NewServer(config)
+	server.ConfigPath = *configPath
+	server.ConfigPathExplicit = explicit
 	server.initElasticClient()
 	server.setRoutes()
-	server.listen()
+	go server.watchConfig()
+
+	//reload config on SIGHUP
+	go func() {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		for range hangups {
+			log.Printf("Received SIGHUP, reloading config\n")
+			err := server.reload()
+			if err != nil {
+				log.Printf("Config is not reloaded, the previous config is still active: %s\n", err)
+			}
+		}
+	}()
+
+	//drain in-flight requests on SIGTERM or SIGINT
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-signals
+		log.Printf("Received %s, shutting down\n", sig)
+		err := server.shutdown()
+		if err != nil {
+			log.Printf("Shutdown error: %s\n", err)
+		}
+		close(stopped)
+	}()
+
+	err = server.listen()
+	if err != nil {
+		log.Printf("Server error: %s\n", err)
+		os.Exit(1)
+	}
+	<-stopped
+	shutdownTracing()
 }
\ No newline at end of file
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+
+const MetricsPath      string = "/metrics"
+const MetricsNamespace string = "historyapi"
+
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts {
+		Namespace: MetricsNamespace,
+		Name: "http_requests_total",
+		Help: "Number of handled requests by endpoint and status code.",
+	}, []string { "endpoint", "code" })
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts {
+		Namespace: MetricsNamespace,
+		Name: "http_request_duration_seconds",
+		Help: "Latency of handled requests by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string { "endpoint" })
+
+	elasticRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts {
+		Namespace: MetricsNamespace,
+		Name: "elasticsearch_request_duration_seconds",
+		Help: "Latency of requests to Elasticsearch by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string { "operation" })
+
+	elasticRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts {
+		Namespace: MetricsNamespace,
+		Name: "elasticsearch_request_errors_total",
+		Help: "Number of failed requests to Elasticsearch by operation.",
+	}, []string { "operation" })
+
+	nodeRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts {
+		Namespace: MetricsNamespace,
+		Name: "node_request_duration_seconds",
+		Help: "Latency of requests to the seed node by chain api method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string { "method" })
+
+	nodeRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts {
+		Namespace: MetricsNamespace,
+		Name: "node_request_errors_total",
+		Help: "Number of failed requests to the seed node by chain api method.",
+	}, []string { "method" })
+
+	discoveredIndices = prometheus.NewGaugeVec(prometheus.GaugeOpts {
+		Namespace: MetricsNamespace,
+		Name: "discovered_indices",
+		Help: "Number of discovered Elasticsearch indices by prefix.",
+	}, []string { "prefix" })
+
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts {
+		Namespace: MetricsNamespace,
+		Name: "cache_requests_total",
+		Help: "Number of cache lookups by cache name and result (hit or miss).",
+	}, []string { "cache", "result" })
+
+	coalescedRequests = prometheus.NewCounterVec(prometheus.CounterOpts {
+		Namespace: MetricsNamespace,
+		Name: "coalesced_requests_total",
+		Help: "Number of requests that got result of identical concurrent request by endpoint.",
+	}, []string { "endpoint" })
+
+	rateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts {
+		Namespace: MetricsNamespace,
+		Name: "rate_limited_requests_total",
+		Help: "Number of requests rejected by rate limit by endpoint and limit (key or ip).",
+	}, []string { "endpoint", "limit" })
+
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts {
+		Namespace: MetricsNamespace,
+		Name: "config_reloads_total",
+		Help: "Number of config reloads by result (success or error).",
+	}, []string { "result" })
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpRequestDuration,
+		elasticRequestDuration, elasticRequestErrors,
+		nodeRequestDuration, nodeRequestErrors,
+		discoveredIndices, cacheRequests, coalescedRequests, rateLimitedRequests, configReloads)
+}
+
+
+//instrumentElastic wraps transport used for Elasticsearch requests
+//so that latency and errors of every request are recorded
+func instrumentElastic(base http.RoundTripper) http.RoundTripper {
+	return &instrumentedTransport {
+		Base: base, System: "elasticsearch", Operation: elasticOperation,
+		Duration: elasticRequestDuration, Errors: elasticRequestErrors }
+}
+
+//http client used for seed node requests
+//it records latency and errors of every request
+var nodeHttpClient = &http.Client { Transport: &instrumentedTransport {
+	Base: http.DefaultTransport, System: "nodeos", Operation: nodeMethod,
+	Duration: nodeRequestDuration, Errors: nodeRequestErrors } }
+
+
+//instrumentedTransport is http.RoundTripper that observes
+//duration and errors of requests labeled by operation name
+//and traces every request with a client span
+type instrumentedTransport struct {
+	Base      http.RoundTripper
+	System    string
+	Operation func(r *http.Request) string
+	Duration  *prometheus.HistogramVec
+	Errors    *prometheus.CounterVec
+}
+
+func (t *instrumentedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	operation := t.Operation(r)
+	r, span := startClientSpan(r, t.System, operation)
+	defer span.End()
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(r)
+	t.Duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		t.Errors.WithLabelValues(operation).Inc()
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	return resp, err
+}
+
+
+//elasticOperation returns name of Elasticsearch api called by the request,
+//e.g. count for /action_traces-1/_count and cat for /_cat/indices
+func elasticOperation(r *http.Request) string {
+	for _, part := range strings.Split(r.URL.Path, "/") {
+		if strings.HasPrefix(part, "_") && len(part) > 1 {
+			return part[1:]
+		}
+	}
+	return "other"
+}
+
+//nodeMethod returns chain api method called by the request,
+//e.g. get_info for /v1/chain/get_info
+func nodeMethod(r *http.Request) string {
+	path := strings.TrimRight(r.URL.Path, "/")
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+
+//observeCache counts hit or miss of the named cache
+func observeCache(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+
+//withMetrics takes endpoint name as an argument and returns middleware
+//that counts requests by status code and observes their latency
+func (s *Server) withMetrics(endpoint string) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := recorder(w)
+			h(rec, r)
+			httpRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+			httpRequests.WithLabelValues(endpoint, strconv.Itoa(rec.Status)).Inc()
+		}
+	}
+}
+
+
+//handleMetrics returns http handler that exposes metrics in prometheus format
+func (s *Server) handleMetrics() http.HandlerFunc {
+	return promhttp.Handler().ServeHTTP
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+
+const RequestIdHeader   string = "X-Request-Id"
+const MaxRequestIdLength   int = 128
+
+type contextKey string
+
+const requestIdKey contextKey = "request_id"
+
+var accessLogger = log.New(os.Stdout, "", 0)
+
+
+//Middleware takes http handler and returns handler that wraps it
+type Middleware func(http.HandlerFunc) http.HandlerFunc
+
+//chain wraps handler with middlewares
+//the first middleware is the outermost one
+func chain(h http.HandlerFunc, middlewares ...Middleware) http.HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+
+//responseRecorder remembers status code and size of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	Status      int
+	Bytes       int
+	WroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.WroteHeader {
+		return
+	}
+	rec.Status = status
+	rec.WroteHeader = true
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if !rec.WroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.Bytes += n
+	return n, err
+}
+
+//recorder returns responseRecorder that wraps w
+//or w itself if it is already wrapped
+func recorder(w http.ResponseWriter) *responseRecorder {
+	if rec, ok := w.(*responseRecorder); ok {
+		return rec
+	}
+	return &responseRecorder { ResponseWriter: w, Status: http.StatusOK }
+}
+
+
+//requestId returns id of the request set by withRequestId middleware
+func requestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func isValidRequestId(id string) bool {
+	if len(id) == 0 || len(id) > MaxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+//withRequestId takes request id from X-Request-Id header or generates a new one,
+//puts it into the request context and sends it back in X-Request-Id header
+func (s *Server) withRequestId(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIdHeader)
+		if !isValidRequestId(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(RequestIdHeader, id)
+		h(w, r.WithContext(context.WithValue(r.Context(), requestIdKey, id)))
+	}
+}
+
+
+//withAccessLog writes a json line with request method, path, status,
+//response size and latency to stdout after the request is handled
+func (s *Server) withAccessLog(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.settings().DisableAccessLog {
+			h(w, r)
+			return
+		}
+		start := time.Now()
+		rec := recorder(w)
+		h(rec, r)
+		entry := map[string]interface{} {
+			"time": start.UTC().Format(time.RFC3339Nano),
+			"request_id": requestId(r.Context()),
+			"method": r.Method,
+			"path": r.URL.Path,
+			"query": redactQuery(r.URL.RawQuery),
+			"status": rec.Status,
+			"bytes": rec.Bytes,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr": r.RemoteAddr,
+			"user_agent": r.UserAgent(),
+		}
+		b, err := json.Marshal(entry)
+		if err == nil {
+			accessLogger.Println(string(b))
+		}
+	}
+}
+
+
+//withRecovery recovers from panic in the handler,
+//logs it with stack trace and responds with 500 error code
+func (s *Server) withRecovery(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := recorder(w)
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				log.Printf("panic in %s request %s: %v\n%s", r.URL.Path, requestId(r.Context()), p, debug.Stack())
+				if !rec.WroteHeader {
+					writeError(rec, r, newInternalError(fmt.Errorf("%v", p)))
+				}
+			}
+		}()
+		h(rec, r)
+	}
+}
+
+
+//withCors sets CORS headers for requests from allowed origins
+//and responds to preflight OPTIONS requests
+func (s *Server) withCors(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := s.settings()
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 || len(st.CorsAllowedOrigins) == 0 {
+			h(w, r)
+			return
+		}
+		allowed := ""
+		for _, o := range st.CorsAllowedOrigins {
+			if o == "*" {
+				allowed = "*"
+				break
+			}
+			if strings.EqualFold(o, origin) {
+				allowed = origin
+				break
+			}
+		}
+		if len(allowed) == 0 {
+			h(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		if allowed != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+		w.Header().Set("Access-Control-Expose-Headers", RequestIdHeader + ", ETag, Retry-After")
+		if r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(st.CorsAllowedHeaders, ", "))
+			if st.CorsMaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(st.CorsMaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h(w, r)
+	}
+}
+
+
+//withConcurrencyLimit responds with 503 error code
+//if max number of concurrent requests is already being handled
+func (s *Server) withConcurrencyLimit(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		//slots are taken from current settings and released to the same
+		//semaphore even if it is replaced by config reload meanwhile
+		slots := s.settings().RequestSlots
+		if slots == nil {
+			h(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			h(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, r, newServerBusyError())
+		}
+	}
+}
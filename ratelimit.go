@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+
+const ApiKeyHeader             string = "X-Api-Key"
+const ApiKeyParam              string = "api_key"
+const DefaultEndpointCost     float64 = 1
+const MaxRateLimitClients         int = 100000
+
+
+//ApiKeyConfig describes client that sends the key
+//in X-Api-Key header or api_key query parameter
+type ApiKeyConfig struct {
+	Key                string `json:"key"`
+	Name               string `json:"name"`
+	//0 means that requests with the key are not limited
+	RatePerSecond     float64 `json:"rate_per_second"`
+	Burst             float64 `json:"burst"`
+}
+
+//defaultEndpointCosts returns number of tokens taken by requests to endpoints
+//that are more expensive than the others
+func defaultEndpointCosts() map[string]float64 {
+	return map[string]float64 {
+		"get_actions": 5,
+		"get_transaction": 2,
+		"get_controlled_accounts_graph": 5,
+	}
+}
+
+
+//tokenBucket is filled with rate tokens per second up to burst tokens
+//every request takes tokens according to the cost of its endpoint
+type tokenBucket struct {
+	Tokens float64
+	Time   time.Time
+}
+
+//take takes cost tokens from the bucket
+//if there are not enough tokens it returns time after which they will be there
+func (b *tokenBucket) take(cost float64, rate float64, burst float64, now time.Time) (bool, time.Duration) {
+	//request that costs more than the bucket can hold needs a full bucket
+	if cost > burst {
+		cost = burst
+	}
+	b.Tokens = math.Min(burst, b.Tokens + now.Sub(b.Time).Seconds() * rate)
+	b.Time = now
+	if b.Tokens >= cost {
+		b.Tokens -= cost
+		return true, 0
+	}
+	return false, time.Duration((cost - b.Tokens) / rate * float64(time.Second))
+}
+
+
+//rateLimiter keeps token buckets of api keys and client addresses
+//rates are passed on every call so that buckets survive config reload
+//buckets of the least recently seen clients are dropped
+//when there are too many of them
+type rateLimiter struct {
+	Lock    sync.Mutex
+	Buckets *lru
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter { Buckets: newLru(MaxRateLimitClients, 0, nil) }
+}
+
+//allow takes cost tokens from the bucket of the client
+//new clients start with a full bucket
+func (l *rateLimiter) allow(client string, cost float64, rate float64, burst float64) (bool, time.Duration) {
+	now := time.Now()
+	l.Lock.Lock()
+	defer l.Lock.Unlock()
+	var bucket *tokenBucket
+	if value, ok := l.Buckets.get(client); ok {
+		bucket = value.(*tokenBucket)
+	} else {
+		bucket = &tokenBucket { Tokens: burst, Time: now }
+		l.Buckets.add(client, bucket, 0)
+	}
+	return bucket.take(cost, rate, burst, now)
+}
+
+
+//requestApiKey returns api key sent in X-Api-Key header or api_key query parameter
+func requestApiKey(r *http.Request) string {
+	if key := r.Header.Get(ApiKeyHeader); len(key) > 0 {
+		return key
+	}
+	return r.URL.Query().Get(ApiKeyParam)
+}
+
+//clientIp returns address of the client
+//taken from the configured header if the server is behind a proxy
+func clientIp(r *http.Request, header string) string {
+	if len(header) > 0 {
+		//the first address of X-Forwarded-For is the original client
+		value := strings.TrimSpace(strings.Split(r.Header.Get(header), ",")[0])
+		if len(value) > 0 {
+			return value
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//redactQuery replaces api key in the query string so that it is not logged
+func redactQuery(rawQuery string) string {
+	if !strings.Contains(rawQuery, ApiKeyParam + "=") {
+		return rawQuery
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	if _, ok := values[ApiKeyParam]; ok {
+		values.Set(ApiKeyParam, MaskedSecret)
+	}
+	return values.Encode()
+}
+
+
+//withRateLimit takes endpoint name as an argument and returns middleware
+//that limits rate of requests with token buckets of api keys
+//and of client addresses for requests without a key
+//requests with unknown key get 401 error,
+//requests over the limit get 429 error with Retry-After header
+func (s *Server) withRateLimit(endpoint string) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			st := s.settings()
+			cost := DefaultEndpointCost
+			if value, ok := st.EndpointCosts[endpoint]; ok {
+				cost = value
+			}
+			var client string
+			var limit string
+			var rate, burst float64
+			if key := requestApiKey(r); len(key) > 0 {
+				apiKey, ok := st.ApiKeys[key]
+				if !ok {
+					writeError(w, r, newUnauthorizedError())
+					return
+				}
+				client, limit, rate, burst = "key:" + apiKey.Key, "key", apiKey.RatePerSecond, apiKey.Burst
+			} else {
+				client, limit, rate, burst = "ip:" + clientIp(r, st.ClientIpHeader), "ip", st.RateLimitPerSecond, st.RateLimitBurst
+			}
+			if rate <= 0 {
+				h(w, r)
+				return
+			}
+			ok, wait := st.RateLimiter.allow(client, cost, rate, burst)
+			if !ok {
+				rateLimitedRequests.WithLabelValues(endpoint, limit).Inc()
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+				writeError(w, r, newTooManyRequestsError())
+				return
+			}
+			h(w, r)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"github.com/olivere/elastic"
+)
+
+
+const DefaultConfigPollIntervalMs int64 = 5000
+
+
+//Settings holds everything that can be changed by config reload
+//handlers take settings once per request so a request
+//is served with the same settings from start to end
+type Settings struct {
+	Config Config
+	SeedNode string
+	ElasticUrls []string
+	ElasticClient *elastic.Client
+	//names of indices in ES by index type
+	IndexPrefixNames map[string]string
+	RequestTimeout time.Duration
+	EndpointTimeouts map[string]time.Duration
+	DisableAccessLog bool
+	CorsAllowedOrigins []string
+	CorsAllowedHeaders []string
+	CorsMaxAge int
+	//semaphore limiting number of concurrent requests, nil if unlimited
+	RequestSlots chan struct{}
+	//interval of config file polling, 0 if polling is disabled
+	ConfigPollInterval time.Duration
+	//cache of irreversible responses, nil if disabled
+	Cache *responseCache
+	CacheMaxAge int
+	//api keys by key with default burst applied
+	ApiKeys map[string]ApiKeyConfig
+	RateLimitPerSecond float64
+	RateLimitBurst float64
+	EndpointCosts map[string]float64
+	ClientIpHeader string
+	//token buckets of clients, kept across reloads
+	RateLimiter *rateLimiter
+	ActionsLimits ActionsQueryLimits
+}
+
+//newSettings creates settings from config without elasticsearch client
+//semaphore of concurrent requests is taken from previous settings
+//if its size is not changed so that running requests are still counted
+//the same applies to response cache so that it is not dropped on reload
+//and to token buckets of rate limits so that reload does not refill them
+func newSettings(config Config, previous *Settings) *Settings {
+	st := new(Settings)
+	st.Config = config
+	st.SeedNode = config.SeedNode
+	st.ElasticUrls = elasticUrls(config)
+	st.IndexPrefixNames = make(map[string]string)
+	for _, prefix := range IndexPrefixes {
+		st.IndexPrefixNames[prefix] = prefix
+		if name, ok := config.IndexPrefixes[prefix]; ok && len(name) > 0 {
+			st.IndexPrefixNames[prefix] = name
+		}
+	}
+	st.RequestTimeout = milliseconds(config.RequestTimeoutMs, DefaultRequestTimeoutMs)
+	st.EndpointTimeouts = make(map[string]time.Duration)
+	for endpoint, timeout := range config.EndpointTimeoutsMs {
+		if timeout > 0 {
+			st.EndpointTimeouts[endpoint] = time.Duration(timeout) * time.Millisecond
+		}
+	}
+	st.DisableAccessLog = config.DisableAccessLog
+	st.CorsAllowedOrigins = config.CorsAllowedOrigins
+	st.CorsAllowedHeaders = config.CorsAllowedHeaders
+	if len(st.CorsAllowedHeaders) == 0 {
+		st.CorsAllowedHeaders = []string { "Content-Type", RequestIdHeader, ApiKeyHeader }
+	}
+	st.CorsMaxAge = config.CorsMaxAgeSeconds
+	if config.MaxConcurrentRequests > 0 {
+		if previous != nil && cap(previous.RequestSlots) == config.MaxConcurrentRequests {
+			st.RequestSlots = previous.RequestSlots
+		} else {
+			st.RequestSlots = make(chan struct{}, config.MaxConcurrentRequests)
+		}
+	}
+	if config.ConfigPollIntervalMs > 0 {
+		st.ConfigPollInterval = time.Duration(config.ConfigPollIntervalMs) * time.Millisecond
+	}
+	if config.CacheMaxEntries > 0 {
+		if previous != nil && previous.Cache != nil &&
+			previous.Config.CacheMaxEntries == config.CacheMaxEntries &&
+			previous.Config.DiskCacheDir == config.DiskCacheDir &&
+			previous.Config.DiskCacheMaxMb == config.DiskCacheMaxMb {
+			st.Cache = previous.Cache
+		} else {
+			st.Cache = newResponseCache(config.CacheMaxEntries, config.DiskCacheDir, config.DiskCacheMaxMb)
+		}
+	}
+	st.CacheMaxAge = config.CacheMaxAgeSeconds
+	if st.CacheMaxAge <= 0 {
+		st.CacheMaxAge = DefaultCacheMaxAgeSeconds
+	}
+	//burst defaults to one second of requests
+	//but at least one request to the most expensive endpoint
+	maxCost := DefaultEndpointCost
+	for _, cost := range config.EndpointCosts {
+		maxCost = math.Max(maxCost, cost)
+	}
+	st.ApiKeys = make(map[string]ApiKeyConfig)
+	for _, key := range config.ApiKeys {
+		if key.Burst <= 0 {
+			key.Burst = math.Max(key.RatePerSecond, maxCost)
+		}
+		st.ApiKeys[key.Key] = key
+	}
+	st.RateLimitPerSecond = config.RateLimitPerSecond
+	st.RateLimitBurst = config.RateLimitBurst
+	if st.RateLimitBurst <= 0 {
+		st.RateLimitBurst = math.Max(config.RateLimitPerSecond, maxCost)
+	}
+	st.EndpointCosts = config.EndpointCosts
+	st.ClientIpHeader = config.ClientIpHeader
+	st.ActionsLimits = newActionsQueryLimits(config)
+	if previous != nil {
+		st.RateLimiter = previous.RateLimiter
+	} else {
+		st.RateLimiter = newRateLimiter()
+	}
+	return st
+}
+
+//settings returns current settings of the server
+func (s *Server) settings() *Settings {
+	s.SettingsLock.RLock()
+	defer s.SettingsLock.RUnlock()
+	return s.Settings
+}
+
+
+//reload reads config again and replaces settings of the server
+//invalid config is rejected and the current settings stay active
+func (s *Server) reload() error {
+	s.ReloadLock.Lock()
+	defer s.ReloadLock.Unlock()
+	err := s.applyConfig()
+	s.SettingsLock.Lock()
+	s.ReloadTime = time.Now()
+	s.ReloadError = err
+	if err == nil {
+		s.Reloads++
+	}
+	s.SettingsLock.Unlock()
+	if err != nil {
+		configReloads.WithLabelValues("error").Inc()
+		return err
+	}
+	configReloads.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (s *Server) applyConfig() error {
+	config, err := loadConfig(s.ConfigPath, s.ConfigPathExplicit)
+	if err != nil {
+		return err
+	}
+	err = config.validate()
+	if err != nil {
+		return err
+	}
+	old := s.settings()
+	st := newSettings(config, old)
+	st.ElasticClient, err = newElasticClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Elasticsearch client: %s", err)
+	}
+
+	if changed := restartRequired(s.Config, config); len(changed) > 0 {
+		log.Printf("Config properties %s are changed, restart is required to apply them\n",
+			strings.Join(changed, ", "))
+	}
+	s.SettingsLock.Lock()
+	s.Settings = st
+	s.SettingsLock.Unlock()
+
+	//requests that already use the old client can finish,
+	//stop only disables its health checks
+	if old.ElasticClient != nil {
+		old.ElasticClient.Stop()
+	}
+	if old.SeedNode != st.SeedNode {
+		nodeHttpClient.CloseIdleConnections()
+	}
+	//index list and chain info are fetched with new settings right away
+	select {
+	case s.RefreshIndices <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+//restartRequired returns names of changed config properties
+//that are applied only on start
+func restartRequired(old Config, config Config) []string {
+	changed := make([]string, 0)
+	check := func(property string, differs bool) {
+		if differs {
+			changed = append(changed, property)
+		}
+	}
+	check("port", old.Port != config.Port)
+	check("read_timeout_ms", old.ReadTimeoutMs != config.ReadTimeoutMs)
+	check("write_timeout_ms", old.WriteTimeoutMs != config.WriteTimeoutMs)
+	check("idle_timeout_ms", old.IdleTimeoutMs != config.IdleTimeoutMs)
+	check("shutdown_timeout_ms", old.ShutdownTimeoutMs != config.ShutdownTimeoutMs)
+	check("tracing_enabled", old.TracingEnabled != config.TracingEnabled)
+	check("otlp_endpoint", old.OtlpEndpoint != config.OtlpEndpoint)
+	check("otlp_insecure", old.OtlpInsecure != config.OtlpInsecure)
+	check("tracing_sample_ratio", old.TracingSampleRatio != config.TracingSampleRatio)
+	return changed
+}
+
+
+//watchConfig polls modification time of the config file
+//and reloads config when it changes until the server is stopped
+func (s *Server) watchConfig() {
+	modTime := configModTime(s.ConfigPath)
+	for {
+		interval := s.settings().ConfigPollInterval
+		enabled := interval > 0
+		if !enabled {
+			//polling can be enabled again by reload on SIGHUP
+			interval = time.Duration(DefaultConfigPollIntervalMs) * time.Millisecond
+		}
+		select {
+		case <-s.StopIndices:
+			return
+		case <-time.After(interval):
+		}
+		current := configModTime(s.ConfigPath)
+		if !enabled || current.IsZero() || current.Equal(modTime) {
+			continue
+		}
+		modTime = current
+		log.Printf("Config file %s is changed, reloading\n", s.ConfigPath)
+		if err := s.reload(); err != nil {
+			log.Printf("Config is not reloaded, the previous config is still active: %s\n", err)
+		}
+	}
+}
+
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+
+//configStatus returns path of the config file and results of reloads
+func (s *Server) configStatus() ConfigStatus {
+	s.SettingsLock.RLock()
+	defer s.SettingsLock.RUnlock()
+	status := ConfigStatus { Path: s.ConfigPath, Reloads: s.Reloads }
+	if !s.ReloadTime.IsZero() {
+		status.LastReload = s.ReloadTime.UTC().Format(time.RFC3339)
+	}
+	if s.ReloadError != nil {
+		status.LastError = s.ReloadError.Error()
+	}
+	return status
+}
@@ -2,36 +2,118 @@ package main
 
 import (
 	"fmt"
+	"context"
 	"time"
 	"sync"
-	"io/ioutil"
 	"net/http"
 	"encoding/json"
-	"github.com/olivere/elastic"
 )
 
 
 const ApiPath                      string = "/v1/history/"
+const HealthPath                   string = "/health"
+const ReadyPath                    string = "/ready"
 const AccountsIndexPrefix          string = "accounts"
 const TransactionsIndexPrefix      string = "transactions"
 const TransactionTracesIndexPrefix string = "transaction_traces"
 const ActionTracesIndexPrefix      string = "action_traces"
 const FetchIndexListIntervalSeconds int64 = 30
+const DefaultRequestTimeoutMs       int64 = 30000
+const DefaultReadTimeoutMs          int64 = 10000
+const DefaultWriteTimeoutMs         int64 = 60000
+const DefaultIdleTimeoutMs          int64 = 120000
+const DefaultShutdownTimeoutMs      int64 = 30000
+
+var IndexPrefixes = []string {
+	AccountsIndexPrefix,
+	TransactionsIndexPrefix,
+	TransactionTracesIndexPrefix,
+	ActionTracesIndexPrefix }
 
 
 type Config struct {
-	Port       uint32 `json:"port"`
-	ElasticUrl string `json:"elastic_url"`
-	SeedNode   string `json:"seed_node"`
+	Port                         uint32 `json:"port"`
+	ElasticUrl                   string `json:"elastic_url"`
+	SeedNode                     string `json:"seed_node"`
+	RequestTimeoutMs              int64 `json:"request_timeout_ms"`
+	EndpointTimeoutsMs map[string]int64 `json:"endpoint_timeouts_ms"`
+	DisableAccessLog               bool `json:"disable_access_log"`
+	CorsAllowedOrigins         []string `json:"cors_allowed_origins"`
+	CorsAllowedHeaders         []string `json:"cors_allowed_headers"`
+	CorsMaxAgeSeconds               int `json:"cors_max_age_seconds"`
+	ReadTimeoutMs                 int64 `json:"read_timeout_ms"`
+	WriteTimeoutMs                int64 `json:"write_timeout_ms"`
+	IdleTimeoutMs                 int64 `json:"idle_timeout_ms"`
+	ShutdownTimeoutMs             int64 `json:"shutdown_timeout_ms"`
+	MaxConcurrentRequests           int `json:"max_concurrent_requests"`
+	TracingEnabled                 bool `json:"tracing_enabled"`
+	OtlpEndpoint                 string `json:"otlp_endpoint"`
+	OtlpInsecure                   bool `json:"otlp_insecure"`
+	TracingSampleRatio          float64 `json:"tracing_sample_ratio"`
+	IndexPrefixes     map[string]string `json:"index_prefixes"`
+	CacheMaxEntries                 int `json:"cache_max_entries"`
+	DiskCacheDir                 string `json:"disk_cache_dir"`
+	DiskCacheMaxMb                int64 `json:"disk_cache_max_mb"`
+	ElasticUsername              string `json:"elastic_username"`
+	ElasticPassword              string `json:"elastic_password"`
+	ElasticCaFile                string `json:"elastic_ca_file"`
+	ElasticInsecureSkipVerify      bool `json:"elastic_insecure_skip_verify"`
+	ElasticUrls                []string `json:"elastic_urls"`
+	ElasticApiKey                string `json:"elastic_api_key"`
+	ElasticCertFile              string `json:"elastic_cert_file"`
+	ElasticKeyFile               string `json:"elastic_key_file"`
+	ElasticDisableHealthcheck      bool `json:"elastic_disable_healthcheck"`
+	ElasticHealthcheckIntervalMs  int64 `json:"elastic_healthcheck_interval_ms"`
+	ElasticHealthcheckTimeoutMs   int64 `json:"elastic_healthcheck_timeout_ms"`
+	ElasticMaxRetries               int `json:"elastic_max_retries"`
+	ElasticRetryInitialBackoffMs  int64 `json:"elastic_retry_initial_backoff_ms"`
+	ElasticRetryMaxBackoffMs      int64 `json:"elastic_retry_max_backoff_ms"`
+	ConfigPollIntervalMs          int64 `json:"config_poll_interval_ms"`
+	CacheMaxAgeSeconds              int `json:"cache_max_age_seconds"`
+	ApiKeys              []ApiKeyConfig `json:"api_keys"`
+	RateLimitPerSecond          float64 `json:"rate_limit_per_second"`
+	RateLimitBurst              float64 `json:"rate_limit_burst"`
+	EndpointCosts    map[string]float64 `json:"endpoint_costs"`
+	ClientIpHeader               string `json:"client_ip_header"`
+	ActionsMaxPageSize            int64 `json:"actions_max_page_size"`
+	ActionsMaxIndices               int `json:"actions_max_indices"`
+	ActionsMaxWindow              int64 `json:"actions_max_window"`
+	ActionsLimitMode             string `json:"actions_limit_mode"`
+	ActionsCountConcurrency         int `json:"actions_count_concurrency"`
+	ActionsMode                  string `json:"actions_mode"`
 }
 
 
 type Server struct {
+	//config the server was created with
+	Config Config
+	//path of the config file and whether it was set explicitly
+	ConfigPath string
+	ConfigPathExplicit bool
 	Port uint32
-	SeedNode string
-	ElasticUrl string
-    ElasticClient *elastic.Client
 	Indices map[string][]string
+	ShutdownTimeout time.Duration
+	HttpServer *http.Server
+	Mux *http.ServeMux
+	//settings that are replaced on config reload
+	Settings *Settings
+	SettingsLock sync.RWMutex
+	//serializes config reloads
+	ReloadLock sync.Mutex
+	Reloads int64
+	ReloadTime time.Time
+	ReloadError error
+	//concurrent identical requests share one execution
+	Flights flightGroup
+	//closed to stop fetching of index list and watching of config file
+	StopIndices chan struct{}
+	//wakes up fetching of index list before the interval expires
+	RefreshIndices chan struct{}
+	//last successfully fetched info from the seed node
+	ChainInfo *ChainGetInfoResult
+	ChainInfoTime time.Time
+	ChainInfoError error
+	ChainInfoLock sync.RWMutex
 	//syncronization for Indices
 	Wg1 sync.WaitGroup
 	Wg2 sync.WaitGroup
@@ -40,44 +122,141 @@ type Server struct {
 func NewServer(config Config) *Server {
 	s := new(Server)
 	s.Port = config.Port
-	s.SeedNode = config.SeedNode
-	s.ElasticUrl = config.ElasticUrl
+	s.Config = config
+	s.ConfigPath = ConfigFilename
+	s.Settings = newSettings(config, nil)
+	s.ShutdownTimeout = milliseconds(config.ShutdownTimeoutMs, DefaultShutdownTimeoutMs)
+	s.StopIndices = make(chan struct{})
+	s.RefreshIndices = make(chan struct{}, 1)
+	s.Mux = http.NewServeMux()
+	s.HttpServer = &http.Server {
+		Addr: ":" + fmt.Sprint(s.Port),
+		Handler: s.Mux,
+		ReadTimeout: milliseconds(config.ReadTimeoutMs, DefaultReadTimeoutMs),
+		WriteTimeout: milliseconds(config.WriteTimeoutMs, DefaultWriteTimeoutMs),
+		IdleTimeout: milliseconds(config.IdleTimeoutMs, DefaultIdleTimeoutMs),
+	}
     return s
 }
 
+//milliseconds converts value from config to duration
+//and uses default value if the value is not set
+func milliseconds(value int64, defaultValue int64) time.Duration {
+	if value <= 0 {
+		value = defaultValue
+	}
+	return time.Duration(value) * time.Millisecond
+}
+
 
-func (s * Server) listen() {
-	err := http.ListenAndServe(":" + fmt.Sprint(s.Port), nil)
-    if err != nil {
-        panic(err)
-    }
+//listen serves http requests until shutdown() is called
+func (s * Server) listen() error {
+	err := s.HttpServer.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+
+//shutdown stops accepting new connections, waits for in-flight requests
+//until ShutdownTimeout expires, stops fetching of index list
+//and closes elasticsearch client
+func (s *Server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	err := s.HttpServer.Shutdown(ctx)
+	close(s.StopIndices)
+	if client := s.settings().ElasticClient; client != nil {
+		client.Stop()
+	}
+	return err
 }
 
 
 func (s *Server) initElasticClient() {
-	client, err := elastic.NewClient(
-		elastic.SetURL(s.ElasticUrl),
-		elastic.SetSniff(false))
+	client, err := newElasticClient(s.Config)
 	if err != nil {
 		panic(err)
 	} else {
-		s.ElasticClient = client
+		s.SettingsLock.Lock()
+		s.Settings.ElasticClient = client
+		s.SettingsLock.Unlock()
 		go func () {
+			//requests of the poller are cancelled on shutdown
+			stopCtx, stop := context.WithCancel(context.Background())
+			defer stop()
+			go func() {
+				<-s.StopIndices
+				stop()
+			}()
 			for {
-				s.fetchIndices()
-				time.Sleep(time.Duration(FetchIndexListIntervalSeconds) * time.Second)
+				//hung elasticsearch or seed node must not stop index discovery
+				ctx, cancel := context.WithTimeout(stopCtx, time.Duration(ReadinessTimeoutMs) * time.Millisecond)
+				s.fetchIndices(ctx)
+				cancel()
+				ctx, cancel = context.WithTimeout(stopCtx, time.Duration(ReadinessTimeoutMs) * time.Millisecond)
+				s.fetchChainInfo(ctx)
+				cancel()
+				select {
+				case <-s.StopIndices:
+					return
+				case <-s.RefreshIndices:
+				case <-time.After(time.Duration(FetchIndexListIntervalSeconds) * time.Second):
+				}
 			}
 		}()
 	}
 }
 
 func (s *Server) setRoutes() {
-	http.HandleFunc(ApiPath + "get_actions", s.onlyGetOrPost(s.handleGetActions()))
-	http.HandleFunc(ApiPath + "get_transaction", s.onlyGetOrPost(s.handleGetTransaction()))
-	http.HandleFunc(ApiPath + "get_key_accounts", s.onlyGetOrPost(s.handleGetKeyAccounts()))
-	http.HandleFunc(ApiPath + "get_controlled_accounts", s.onlyGetOrPost(s.handleGetControlledAccounts()))
+	s.handle("get_actions", s.handleGetActions())
+	s.handle("get_transaction", s.handleGetTransaction())
+	s.handle("get_key_accounts", s.handleGetKeyAccounts())
+	s.handle("get_controlled_accounts", s.handleGetControlledAccounts())
+	s.handle("get_account", s.handleGetAccount())
+	s.handle("get_controlled_accounts_graph", s.handleGetControlledAccountsGraph())
+	s.handle("status", s.handleStatus())
+	s.Mux.HandleFunc(HealthPath, chain(s.handleHealth(), s.withRequestId, s.withRecovery))
+	s.Mux.HandleFunc(ReadyPath, chain(s.handleReady(), s.withRequestId, s.withRecovery))
+	s.Mux.HandleFunc(MetricsPath, chain(s.handleMetrics(), s.withRequestId, s.withRecovery))
 }
 
+//handle registers handler of the history api endpoint
+//wrapped with the common middleware chain
+func (s *Server) handle(endpoint string, h http.HandlerFunc) {
+	s.Mux.HandleFunc(ApiPath + endpoint, chain(h,
+		s.withRequestId,
+		s.withTracing(endpoint),
+		s.withAccessLog,
+		s.withMetrics(endpoint),
+		s.withRecovery,
+		s.withCors,
+		s.onlyGetOrPost,
+		s.withRateLimit(endpoint),
+		s.withConcurrencyLimit,
+		s.withTimeout(endpoint)))
+}
+
+
+//withTimeout takes endpoint name as an argument and returns middleware
+//that runs handler with request context limited
+//by the endpoint timeout from config or by the default request timeout
+//the context is also cancelled when client disconnects
+func (s *Server) withTimeout(endpoint string) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			st := s.settings()
+			timeout, ok := st.EndpointTimeouts[endpoint]
+			if !ok {
+				timeout = st.RequestTimeout
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			h(w, r.WithContext(ctx))
+		}
+	}
+}
 
 //onlyGet take function (http handler) as an argument
 //and returns function that takes http.ResponseWriter and *http.Request
@@ -86,22 +265,31 @@ func (s *Server) setRoutes() {
 func (s *Server) onlyGetOrPost(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if (r.Method != http.MethodGet && r.Method != http.MethodPost) {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			response := ErrorResult { Code: http.StatusMethodNotAllowed, Message: "Invalid request method." }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, newMethodNotAllowedError())
 			return
 		}
 		h(w, r)
 	}
 }
 
-func (s *Server) fetchIndices() {
-	prefixes := []string {
-		AccountsIndexPrefix,
-		TransactionsIndexPrefix,
-		TransactionTracesIndexPrefix,
-		ActionTracesIndexPrefix }
-	tmp := getIndices(s.ElasticUrl, prefixes)
+//fetchIndices replaces the index list with the one found in ES,
+//the list is kept if the request timed out or was cancelled
+func (s *Server) fetchIndices(ctx context.Context) {
+	st := s.settings()
+	names := make([]string, 0, len(IndexPrefixes))
+	for _, prefix := range IndexPrefixes {
+		names = append(names, st.IndexPrefixNames[prefix])
+	}
+	found := getIndices(ctx, st.ElasticClient, names)
+	if ctx.Err() != nil {
+		return
+	}
+	//indices are keyed by index type whatever prefix they have in ES
+	tmp := make(map[string][]string)
+	for _, prefix := range IndexPrefixes {
+		tmp[prefix] = found[st.IndexPrefixNames[prefix]]
+		discoveredIndices.WithLabelValues(prefix).Set(float64(len(tmp[prefix])))
+	}
 	s.Wg1.Add(1)
 	s.Wg2.Wait()
 	s.Indices = tmp
@@ -118,26 +306,21 @@ func (s *Server) getIndices() map[string][]string {
 
 //handleGetActions returns http handler that takes
 //http.ResponseWriter and *http.Request as arguments
-//it tries to parse parameters from request body
+//it tries to parse parameters from query string or request body
 //and passes them to getActions()
 //The result of getActions() is encoded and sent as a response
 func (s *Server) handleGetActions() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		bytes, err := ioutil.ReadAll(r.Body)
-		defer r.Body.Close()
+		st := s.settings()
+		var params GetActionsParams
+		err := bindParams(r, &params)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
-
-		var params GetActionsParams
-		err = json.Unmarshal(bytes, &params)
+		err = params.validate(st.ActionsLimits)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
 		if params.Pos == nil {
@@ -148,67 +331,80 @@ func (s *Server) handleGetActions() http.HandlerFunc {
 			params.Offset = new(int64)
 			*params.Offset = -20
 		}
+		if len(params.Mode) == 0 {
+			params.Mode = st.Config.ActionsMode
+		}
+		//only pages counted from the first action of the account can be cached,
+		//pages counted from the last action shift when new actions arrive
+		key := cacheKey("get_actions", params)
+		pageSize := *params.Offset + 1
+		cacheable := *params.Pos >= 0 && *params.Offset >= 0
+		//LIB is taken before the search so it is never newer than the actions
+		lib := nodeLastIrreversibleBlock(r.Context(), st.SeedNode)
+		if cacheable && writeCached(w, r, st, key, lib) {
+			return
+		}
 
-		result, err := getActions(s.ElasticClient, params, s.getIndices())
+		//identical concurrent requests wait for the first one
+		//and get a copy of its result
+		value, shared, err := s.Flights.do(r.Context(), key, func(ctx context.Context) (interface{}, error) {
+			return getActions(ctx, st.ElasticClient, params, s.getIndices(), st.ActionsLimits)
+		})
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
-
-		info, err := getInfo(s.SeedNode)
-		if err == nil {
-			result.LastIrreversibleBlock = info.LastIrreversibleBlockNum
+		if shared {
+			coalescedRequests.WithLabelValues("get_actions").Inc()
 		}
+		result := *value.(*GetActionsResult)
 
-		b, err := json.Marshal(result)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
-			return
+		//incomplete page can get more actions later
+		cacheable = cacheable && int64(len(result.Actions)) == pageSize
+		blockNums := make([]json.RawMessage, 0, len(result.Actions))
+		for _, action := range result.Actions {
+			blockNums = append(blockNums, action.BlockNum)
 		}
-		fmt.Fprintf(w, string(b))
+		writeCacheableResult(w, r, st, key, result, lib, cacheable && s.isIrreversible(blockNums...))
 	}
 }
 
 //handleGetTransaction returns http handler that takes
 //http.ResponseWriter and *http.Request as arguments
-//it tries to parse parameters from request body
+//it tries to parse parameters from query string or request body
 //and passes them to getTransaction()
 //retrieves block from node chain api
 //and appends requested transaction info to getTransaction() result
 //The result is encoded and sent as a response
 func (s *Server) handleGetTransaction() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		bytes, err := ioutil.ReadAll(r.Body)
-		defer r.Body.Close()
+		st := s.settings()
+		var params GetTransactionParams
+		err := bindParams(r, &params)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
-
-		var params GetTransactionParams
-		err = json.Unmarshal(bytes, &params)
+		err = params.validate()
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
+			return
+		}
+		key := cacheKey("get_transaction", params)
+		lib := nodeLastIrreversibleBlock(r.Context(), st.SeedNode)
+		if writeCached(w, r, st, key, lib) {
 			return
 		}
 
-		result, error := getTransaction(s.ElasticClient, params, s.getIndices())
-		if error != nil {
-			w.WriteHeader(error.Code)
-			response := ErrorResult { Code: error.Code, Message: error.Error.Error() }
-			json.NewEncoder(w).Encode(response)
+		result, err := getTransaction(r.Context(), st.ElasticClient, params, s.getIndices())
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 		//get missing fields from v1/chain/get_block
-		txFromBlock, err := getTransactionFromBlock(s.SeedNode, result.BlockNum, result.Id)
+		//transaction without them is not cached
+		txFromBlock, err := getTransactionFromBlock(r.Context(), st.SeedNode, result.BlockNum, result.Id)
+		complete := err == nil
 		if err == nil {
 			var receipt map[string]json.RawMessage
 			err = json.Unmarshal(result.Trx["receipt"], &receipt)
@@ -220,105 +416,133 @@ func (s *Server) handleGetTransaction() http.HandlerFunc {
 				}
 			}
 		}
-
-		info, err := getInfo(s.SeedNode)
-		if err == nil {
-			result.LastIrreversibleBlock = info.LastIrreversibleBlockNum
-		}
-
-		b, err := json.Marshal(result)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
-			return
-		}
-		fmt.Fprintf(w, string(b))
+		writeCacheableResult(w, r, st, key, result, lib, complete && s.isIrreversible(result.BlockNum))
 	}
 }
 
 //handleGetKeyAccounts returns http handler that takes
 //http.ResponseWriter and *http.Request as arguments
-//it tries to parse parameters from request body
+//it tries to parse parameters from query string or request body
 //and passes them to getKeyAccounts()
 //The result of getKeyAccounts() is encoded and sent as a response
 func (s *Server) handleGetKeyAccounts() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		bytes, err := ioutil.ReadAll(r.Body)
-		defer r.Body.Close()
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
-			return
-		}
-
+		st := s.settings()
 		var params GetKeyAccountsParams
-		err = json.Unmarshal(bytes, &params)
+		err := bindParams(r, &params)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
-		
-		result, err := getKeyAccounts(s.ElasticClient, params, s.getIndices())
+		err = params.validate()
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
-		b, err := json.Marshal(result)
+
+		result, err := getKeyAccounts(r.Context(), st.ElasticClient, params, s.getIndices())
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
-		fmt.Fprintf(w, string(b))
+		if params.Extended {
+			result.Accounts = fillKeyWeights(r.Context(), st.SeedNode, params.PublicKey, result.Accounts)
+		}
+		writeResult(w, r, result)
 	}
 }
 
 //handleGetControlledAccounts returns http handler that takes
 //http.ResponseWriter and *http.Request as arguments
-//it tries to parse parameters from request body
+//it tries to parse parameters from query string or request body
 //and passes them to getControlledAccounts()
 //The result of getControlledAccounts() is encoded and sent as a response
 func (s *Server) handleGetControlledAccounts() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		bytes, err := ioutil.ReadAll(r.Body)
-		defer r.Body.Close()
+		st := s.settings()
+		var params GetControlledAccountsParams
+		err := bindParams(r, &params)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
+			return
+		}
+		err = params.validate()
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		var params GetControlledAccountsParams
-		err = json.Unmarshal(bytes, &params)
+		result, err := getControlledAccounts(r.Context(), st.ElasticClient, params, s.getIndices())
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			response := ErrorResult { Code: http.StatusBadRequest, Message: "Invalid arguments." }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
+		if params.Extended {
+			result.Accounts = fillControllerWeights(r.Context(), st.SeedNode, params.ControllingAccount, result.Accounts)
+		}
+		writeResult(w, r, result)
+	}
+}
 
-		result, err := getControlledAccounts(s.ElasticClient, params, s.getIndices())
+//handleGetAccount returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from query string or request body
+//and passes them to getAccount()
+//The result of getAccount() is encoded and sent as a response
+func (s *Server) handleGetAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := s.settings()
+		var params GetAccountParams
+		err := bindParams(r, &params)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
-		b, err := json.Marshal(result)
+		err = params.validate()
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			response := ErrorResult { Code: http.StatusInternalServerError, Message: err.Error() }
-			json.NewEncoder(w).Encode(response)
+			writeError(w, r, err)
 			return
 		}
-		fmt.Fprintf(w, string(b))
+
+		result, err := getAccount(r.Context(), st.ElasticClient, params, s.getIndices())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeResult(w, r, result)
+	}
+}
+
+//handleGetControlledAccountsGraph returns http handler that takes
+//http.ResponseWriter and *http.Request as arguments
+//it tries to parse parameters from query string or request body
+//and passes them to getControlledAccountsGraph()
+//The result is encoded as json or Graphviz DOT and sent as a response
+func (s *Server) handleGetControlledAccountsGraph() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := s.settings()
+		var params GetControlledAccountsGraphParams
+		err := bindParams(r, &params)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		err = params.validate()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		result, err := getControlledAccountsGraph(r.Context(), st.ElasticClient, params, s.getIndices())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if params.Format == GraphFormatDot {
+			w.Header().Set("Content-Type", "text/vnd.graphviz")
+			w.Write(graphToDot(result))
+			return
+		}
+		writeResult(w, r, result)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+
+//chain info older than this is considered stale by readiness check
+const MaxChainInfoAgeSeconds int64 = 3 * FetchIndexListIntervalSeconds
+//also the timeout of every request of the index poller
+const ReadinessTimeoutMs     int64 = 5000
+
+
+//fetchChainInfo retrieves info from the seed node and remembers it
+//together with the time of the last successful request
+func (s *Server) fetchChainInfo(ctx context.Context) {
+	info, err := getInfo(ctx, s.settings().SeedNode)
+	s.ChainInfoLock.Lock()
+	defer s.ChainInfoLock.Unlock()
+	s.ChainInfoError = err
+	if err == nil {
+		s.ChainInfo = info
+		s.ChainInfoTime = time.Now()
+	}
+}
+
+//getChainInfo returns the last successfully fetched chain info,
+//time when it was fetched and the error of the last request
+func (s *Server) getChainInfo() (*ChainGetInfoResult, time.Time, error) {
+	s.ChainInfoLock.RLock()
+	defer s.ChainInfoLock.RUnlock()
+	return s.ChainInfo, s.ChainInfoTime, s.ChainInfoError
+}
+
+
+//handleHealth returns http handler that responds with 200
+//while the process is able to serve requests
+func (s *Server) handleHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeResult(w, r, map[string]string { "status": "ok" })
+	}
+}
+
+
+//handleReady returns http handler that checks that elasticsearch is reachable,
+//indices of every type are discovered and chain info is fresh
+//it responds with 200 if all checks pass and with 503 otherwise
+func (s *Server) handleReady() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(ReadinessTimeoutMs) * time.Millisecond)
+		defer cancel()
+		result := ReadinessResult { Ready: true, Checks: make(map[string]string) }
+		fail := func(check string, message string) {
+			result.Ready = false
+			result.Checks[check] = message
+		}
+
+		_, err := s.settings().ElasticClient.ClusterHealth().Do(ctx)
+		if err != nil {
+			fail("elasticsearch", err.Error())
+		} else {
+			result.Checks["elasticsearch"] = "ok"
+		}
+
+		indices := s.getIndices()
+		result.Checks["indices"] = "ok"
+		for _, prefix := range IndexPrefixes {
+			if len(indices[prefix]) == 0 {
+				fail("indices", "no " + prefix + " indices discovered")
+			}
+		}
+
+		_, updated, err := s.getChainInfo()
+		if updated.IsZero() {
+			message := "chain info was never fetched"
+			if err != nil {
+				message = err.Error()
+			}
+			fail("seed_node", message)
+		} else if time.Since(updated) > time.Duration(MaxChainInfoAgeSeconds) * time.Second {
+			fail("seed_node", "chain info was last fetched at " + updated.UTC().Format(time.RFC3339))
+		} else {
+			result.Checks["seed_node"] = "ok"
+		}
+
+		b, _ := json.Marshal(result)
+		w.Header().Set("Content-Type", "application/json")
+		if !result.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(b)
+	}
+}
+
+
+//handleStatus returns http handler that reports discovered indices
+//with their doc counts and block ranges, indexing lag and seed node status
+func (s *Server) handleStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := s.settings()
+		result := new(StatusResult)
+
+		health, err := st.ElasticClient.ClusterHealth().Do(r.Context())
+		if err != nil {
+			result.Elasticsearch.Error = err.Error()
+		} else {
+			result.Elasticsearch.Reachable = true
+			result.Elasticsearch.ClusterStatus = health.Status
+		}
+
+		result.Indices, err = getIndicesStatus(r.Context(), st.ElasticClient, s.getIndices())
+		if err != nil {
+			if r.Context().Err() != nil {
+				writeError(w, r, err)
+				return
+			}
+			result.Indices = make(map[string][]IndexStatus)
+		}
+		//blocks are indexed together with their traces
+		for _, prefix := range []string { ActionTracesIndexPrefix, TransactionTracesIndexPrefix } {
+			for _, index := range result.Indices[prefix] {
+				if index.MaxBlockNum != nil &&
+					(result.LastIndexedBlock == nil || *index.MaxBlockNum > *result.LastIndexedBlock) {
+					result.LastIndexedBlock = index.MaxBlockNum
+				}
+			}
+		}
+
+		info, updated, err := s.getChainInfo()
+		result.SeedNode.Url = st.SeedNode
+		result.SeedNode.Reachable = err == nil && !updated.IsZero()
+		if err != nil {
+			result.SeedNode.Error = err.Error()
+		}
+		if info != nil {
+			result.SeedNode.HeadBlockNum = info.HeadBlockNum
+			result.SeedNode.LastIrreversibleBlock = info.LastIrreversibleBlockNum
+			result.SeedNode.HeadBlockTime = info.HeadBlockTime
+			result.SeedNode.LastUpdate = updated.UTC().Format(time.RFC3339)
+			var headBlockNum uint64
+			if json.Unmarshal(info.HeadBlockNum, &headBlockNum) == nil && result.LastIndexedBlock != nil {
+				lag := int64(headBlockNum) - int64(*result.LastIndexedBlock)
+				result.IndexingLag = &lag
+			}
+		}
+		result.Config = s.configStatus()
+		writeResult(w, r, result)
+	}
+}
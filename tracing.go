@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+
+const TracerName             string = "github.com/atticlab/eos-es-historyapi"
+const TracingServiceName     string = "eos-es-historyapi"
+const DefaultOtlpEndpoint    string = "localhost:4318"
+const TracingShutdownTimeoutMs int64 = 5000
+
+
+var tracer = otel.Tracer(TracerName)
+
+
+//initTracing sets up W3C trace context propagation and,
+//if tracing is enabled in config, OTLP exporter of spans
+//returns function that flushes spans and stops the exporter
+func initTracing(config Config) func() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	if !config.TracingEnabled {
+		return func() {}
+	}
+
+	endpoint := config.OtlpEndpoint
+	if len(endpoint) == 0 {
+		endpoint = DefaultOtlpEndpoint
+	}
+	options := []otlptracehttp.Option { otlptracehttp.WithEndpoint(endpoint) }
+	if config.OtlpInsecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		log.Printf("Failed to create OTLP exporter, tracing is disabled: %s\n", err)
+		return func() {}
+	}
+	sampler := sdktrace.ParentBased(sdktrace.AlwaysSample())
+	if config.TracingSampleRatio < 1 {
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.TracingSampleRatio))
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", TracingServiceName))))
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(TracingShutdownTimeoutMs) * time.Millisecond)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shutdown tracing: %s\n", err)
+		}
+	}
+}
+
+
+//startSpan starts internal span that is a child of the span from ctx
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+//endSpan records error if any and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+
+//withTracing takes endpoint name as an argument and returns middleware
+//that continues trace from traceparent header of the request
+//and wraps handler into a server span
+func (s *Server) withTracing(endpoint string) Middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, endpoint,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.method", r.Method),
+					attribute.String("http.route", r.URL.Path),
+					attribute.String("request_id", requestId(r.Context()))))
+			defer span.End()
+			rec := recorder(w)
+			h(rec, r.WithContext(ctx))
+			span.SetAttributes(attribute.Int("http.status_code", rec.Status))
+			if rec.Status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(rec.Status))
+			}
+		}
+	}
+}
+
+
+//startClientSpan starts client span for outgoing http request
+//and injects trace context into request headers
+func startClientSpan(r *http.Request, system string, operation string) (*http.Request, trace.Span) {
+	ctx, span := tracer.Start(r.Context(), system + " " + operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("peer.service", system),
+			attribute.String("operation", operation),
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path)))
+	r = r.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+	return r, span
+}
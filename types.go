@@ -5,15 +5,28 @@ import (
 )
 
 
-type ErrorWithCode struct {
-	Error error
-	Code int
+type ErrorDetail struct {
+	Message    string `json:"message"`
+	File       string `json:"file"`
+	LineNumber    int `json:"line_number"`
+	Method     string `json:"method"`
+	Field      string `json:"field,omitempty"`
 }
 
 
+type ErrorInfo struct {
+	Code             int `json:"code"`
+	Name          string `json:"name"`
+	What          string `json:"what"`
+	Details []ErrorDetail `json:"details"`
+}
+
+
+//error response in the same format as nodeos uses
 type ErrorResult struct {
-	Code    int `json:"code"`
+	Code       int `json:"code"`
 	Message string `json:"message"`
+	Error ErrorInfo `json:"error"`
 }
 
 
@@ -33,6 +46,34 @@ type ChainGetInfoResult struct {
 	ServerVersionString      json.RawMessage `json:"server_version_string"`
 }
 
+type ChainGetAccountParams struct {
+	AccountName string `json:"account_name"`
+}
+
+type ChainPermission struct {
+	PermName     string `json:"perm_name"`
+	Parent       string `json:"parent"`
+	RequiredAuth struct {
+		Threshold json.RawMessage `json:"threshold"`
+		Keys []struct {
+			Key             string `json:"key"`
+			Weight json.RawMessage `json:"weight"`
+		} `json:"keys"`
+		Accounts []struct {
+			Permission struct {
+				Actor      string `json:"actor"`
+				Permission string `json:"permission"`
+			} `json:"permission"`
+			Weight json.RawMessage `json:"weight"`
+		} `json:"accounts"`
+	} `json:"required_auth"`
+}
+
+type ChainGetAccountResult struct {
+	AccountName              string `json:"account_name"`
+	Permissions []ChainPermission `json:"permissions"`
+}
+
 type GetBlockParams struct {
 	BlockNum json.RawMessage `json:"block_num_or_id"`
 }
@@ -60,6 +101,8 @@ type GetActionsParams struct {
 	AccountName string `json:"account_name"`
 	Pos         *int64 `json:"pos,omitempty"`
 	Offset      *int64 `json:"offset,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	TraceContext  bool `json:"trace_context,omitempty"`
 }
 
 type Action struct {
@@ -68,11 +111,49 @@ type Action struct {
 	BlockNum         json.RawMessage `json:"block_num"`
 	BlockTime        json.RawMessage `json:"block_time"`
 	ActionTrace      json.RawMessage `json:"action_trace"`
+	TraceContext *ActionTraceContext `json:"trace_context,omitempty"`
+}
+
+//ActionTraceContext is the place of the action in the trace of its transaction
+type ActionTraceContext struct {
+	//global sequence of the action that has this one in inline_traces
+	ParentActionSeq json.RawMessage `json:"parent_action_global_seq"`
+	CreatorAction    *CreatorAction `json:"creator_action"`
+	//0 for actions of the transaction, 1 for their inline actions and notifications and so on
+	Depth                       int `json:"depth"`
+	TrxStatus       json.RawMessage `json:"trx_status"`
+	CpuUsageUs      json.RawMessage `json:"cpu_usage_us"`
+	NetUsageWords   json.RawMessage `json:"net_usage_words"`
 }
 
+//CreatorAction is the action that sent an inline action
+type CreatorAction struct {
+	GlobalActionSeq json.RawMessage `json:"global_action_seq"`
+	Account                  string `json:"account"`
+	Name                     string `json:"name"`
+	Receiver        json.RawMessage `json:"receiver"`
+}
+
+//last_irreversible_block is added to encoded result by writeCacheableResult
 type GetActionsResult struct {
-	Actions                      []Action `json:"actions"`
-	LastIrreversibleBlock json.RawMessage `json:"last_irreversible_block"`
+	Actions               []Action `json:"actions"`
+	//set if the page was clamped by a server limit
+	QueryLimit         *QueryLimit `json:"query_limit,omitempty"`
+	//set if unique mode removed repeated traces from the page
+	SkippedActions *SkippedActions `json:"skipped_actions,omitempty"`
+}
+
+//QueryLimit tells which server limit made the page shorter than requested
+type QueryLimit struct {
+	Limit   string `json:"limit"`
+	Value    int64 `json:"value"`
+	Message string `json:"message"`
+}
+
+//SkippedActions tells how many traces of the page unique mode removed
+type SkippedActions struct {
+	Count    int64 `json:"count"`
+	Message string `json:"message"`
 }
 
 
@@ -81,31 +162,168 @@ type GetTransactionParams struct {
 	Id           string `json:"id"`
 }
 
+//last_irreversible_block is added to encoded result by writeCacheableResult
 type GetTransactionResult struct {
 	Id                             string `json:"id"`
 	Trx        map[string]json.RawMessage `json:"trx"`
 	BlockTime             json.RawMessage `json:"block_time"`
 	BlockNum              json.RawMessage `json:"block_num"`
 	Traces                json.RawMessage `json:"traces"`
-	LastIrreversibleBlock json.RawMessage `json:"last_irreversible_block"`
+}
+
+
+//account permission that is satisfied by requested key or controlling account
+//weight and threshold are taken from node chain api and are null if node is unavailable
+type PermissionAccount struct {
+	Account                string `json:"account"`
+	Permission             string `json:"permission"`
+	//permission of the controlling account that has the weight,
+	//only for get_controlled_accounts
+	ControllingPermission  string `json:"controlling_permission,omitempty"`
+	Weight        json.RawMessage `json:"weight"`
+	Threshold     json.RawMessage `json:"threshold"`
 }
 
 
 //get_key_accounts types
 type GetKeyAccountsParams struct {
-	PublicKey string `json:"public_key"`
+	PublicKey  string `json:"public_key"`
+	Permission string `json:"permission,omitempty"`
+	Extended     bool `json:"extended,omitempty"`
+	Limit         int `json:"limit,omitempty"`
+	Cursor     string `json:"cursor,omitempty"`
 }
 
 type GetKeyAccountsResult struct {
-	AccountNames []string `json:"account_names"`
+	AccountNames           []string `json:"account_names"`
+	Accounts    []PermissionAccount `json:"accounts,omitempty"`
+	//upper-bound estimate, accounts whose latest document
+	//no longer matches are counted too
+	Total                     int64 `json:"total"`
+	Cursor                   string `json:"cursor,omitempty"`
+	Truncated                  bool `json:"truncated"`
 }
 
 
 //get_controlled_accounts types
 type GetControlledAccountsParams struct {
 	ControllingAccount string `json:"controlling_account"`
+	Permission         string `json:"permission,omitempty"`
+	Extended             bool `json:"extended,omitempty"`
+	Limit                 int `json:"limit,omitempty"`
+	Cursor             string `json:"cursor,omitempty"`
 }
 
 type GetControlledAccountsResult struct {
-	ControlledAccounts []string `json:"controlled_accounts"`
-}
\ No newline at end of file
+	ControlledAccounts     []string `json:"controlled_accounts"`
+	Accounts    []PermissionAccount `json:"accounts,omitempty"`
+	//upper-bound estimate, accounts whose latest document
+	//no longer matches are counted too
+	Total                     int64 `json:"total"`
+	Cursor                   string `json:"cursor,omitempty"`
+	Truncated                  bool `json:"truncated"`
+}
+
+
+//get_account types
+type GetAccountParams struct {
+	AccountName string `json:"account_name"`
+}
+
+type AccountPermission struct {
+	PermName        string `json:"perm_name"`
+	Keys          []string `json:"keys"`
+	Accounts      []string `json:"accounts"`
+}
+
+type AccountCreation struct {
+	TrxId                     string `json:"trx_id"`
+	GlobalActionSeq  json.RawMessage `json:"global_action_seq"`
+	BlockNum         json.RawMessage `json:"block_num"`
+	BlockTime        json.RawMessage `json:"block_time"`
+}
+
+type GetAccountResult struct {
+	AccountName                   string `json:"account_name"`
+	Creator              json.RawMessage `json:"creator"`
+	AccountCreateTime    json.RawMessage `json:"account_create_time"`
+	Permissions      []AccountPermission `json:"permissions"`
+	PubKeys              []AccountPubKey `json:"pub_keys"`
+	AccountControls     []AccountControl `json:"account_controls"`
+	HasAbi                          bool `json:"has_abi"`
+	CreationTrx         *AccountCreation `json:"creation_trx"`
+}
+
+
+//get_controlled_accounts_graph types
+type GetControlledAccountsGraphParams struct {
+	ControllingAccount string `json:"controlling_account"`
+	Depth                *int `json:"depth,omitempty"`
+	Format             string `json:"format,omitempty"`
+}
+
+type GraphNode struct {
+	Account string `json:"account"`
+	Depth      int `json:"depth"`
+}
+
+type GraphEdge struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Permission string `json:"permission"`
+	Cycle        bool `json:"cycle"`
+}
+
+type GetControlledAccountsGraphResult struct {
+	Root          string `json:"root"`
+	Nodes    []GraphNode `json:"nodes"`
+	Edges    []GraphEdge `json:"edges"`
+	HasCycles       bool `json:"has_cycles"`
+	Truncated       bool `json:"truncated"`
+}
+
+
+//status types
+type IndexStatus struct {
+	Index              string `json:"index"`
+	DocsCount           int64 `json:"docs_count"`
+	MinBlockNum      *uint64 `json:"min_block_num"`
+	MaxBlockNum      *uint64 `json:"max_block_num"`
+}
+
+type ElasticStatus struct {
+	Reachable       bool `json:"reachable"`
+	ClusterStatus string `json:"cluster_status,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type SeedNodeStatus struct {
+	Url                           string `json:"url"`
+	Reachable                       bool `json:"reachable"`
+	HeadBlockNum         json.RawMessage `json:"head_block_num"`
+	LastIrreversibleBlock json.RawMessage `json:"last_irreversible_block"`
+	HeadBlockTime        json.RawMessage `json:"head_block_time"`
+	LastUpdate                    string `json:"last_update,omitempty"`
+	Error                         string `json:"error,omitempty"`
+}
+
+type ConfigStatus struct {
+	Path          string `json:"path"`
+	Reloads        int64 `json:"reloads"`
+	LastReload    string `json:"last_reload,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+}
+
+type StatusResult struct {
+	Elasticsearch              ElasticStatus `json:"elasticsearch"`
+	Indices       map[string][]IndexStatus `json:"indices"`
+	LastIndexedBlock                *uint64 `json:"last_indexed_block"`
+	IndexingLag                      *int64 `json:"indexing_lag"`
+	SeedNode                  SeedNodeStatus `json:"seed_node"`
+	Config                      ConfigStatus `json:"config"`
+}
+
+type ReadinessResult struct {
+	Ready             bool `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
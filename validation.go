@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+
+const MaxNameLength      int   = 13
+//get_actions pos and offset must be exact in float64 and json clients
+//and their sum must not overflow
+const MaxActionsPosition int64 = 1 << 53
+
+var transactionIdRegexp = regexp.MustCompile("^[0-9a-fA-F]{64}$")
+
+
+//isValidName checks that string is a valid EOSIO account or permission name:
+//up to 12 characters from a-z, 1-5 and '.', optional 13th character from a-j, 1-5 and '.'
+//and no trailing dot
+func isValidName(name string) bool {
+	if len(name) == 0 || len(name) > MaxNameLength || name[len(name)-1] == '.' {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if i == MaxNameLength-1 {
+			if !((c >= 'a' && c <= 'j') || (c >= '1' && c <= '5') || c == '.') {
+				return false
+			}
+			continue
+		}
+		if !((c >= 'a' && c <= 'z') || (c >= '1' && c <= '5') || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+
+//validator collects field errors of request parameters
+type validator struct {
+	details []ErrorDetail
+}
+
+func (v *validator) fail(field string, message string) {
+	v.details = append(v.details, ErrorDetail { Field: field, Message: field + ": " + message })
+}
+
+func (v *validator) name(field string, value string, required bool) {
+	if len(value) == 0 {
+		if required {
+			v.fail(field, "field is required")
+		}
+		return
+	}
+	if !isValidName(value) {
+		v.fail(field, "invalid EOSIO name '" + value + "'")
+	}
+}
+
+func (v *validator) transactionId(field string, value string) {
+	if len(value) == 0 {
+		v.fail(field, "field is required")
+		return
+	}
+	if !transactionIdRegexp.MatchString(value) {
+		v.fail(field, "transaction id must be 64 hex characters")
+	}
+}
+
+func (v *validator) publicKey(field string, value string) {
+	if len(value) == 0 {
+		v.fail(field, "field is required")
+		return
+	}
+	if _, err := parsePublicKey(value); err != nil {
+		v.fail(field, "invalid public key: " + err.Error())
+	}
+}
+
+func (v *validator) intRange(field string, value int64, min int64, max int64) {
+	if value < min || value > max {
+		v.fail(field, fmt.Sprintf("must be between %d and %d", min, max))
+	}
+}
+
+func (v *validator) oneOf(field string, value string, values ...string) {
+	for _, allowed := range values {
+		if value == allowed {
+			return
+		}
+	}
+	v.fail(field, fmt.Sprintf("must be one of %q", values))
+}
+
+//err returns bad params error with all collected field errors
+//or nil if parameters are valid
+func (v *validator) err() error {
+	if len(v.details) == 0 {
+		return nil
+	}
+	e := newApiError(http.StatusBadRequest, BadParamsErrorCode,
+		"bad_params_exception", "Invalid request parameters", nil)
+	e.Details = v.details
+	return e
+}
+
+
+//offset is checked against the page size limit unless pages over the limit are clamped,
+//limits.go checks the page again with the per-index counts
+func (p *GetActionsParams) validate(limits ActionsQueryLimits) error {
+	v := new(validator)
+	v.name("account_name", p.AccountName, true)
+	if p.Pos != nil && *p.Pos < -1 {
+		v.fail("pos", "must be -1 or greater")
+	} else if p.Pos != nil && *p.Pos > MaxActionsPosition {
+		v.fail("pos", fmt.Sprintf("must be at most %d", MaxActionsPosition))
+	}
+	if p.Offset != nil {
+		maxOffset := MaxActionsPosition
+		if limits.MaxPageSize > 0 && !limits.Clamp {
+			maxOffset = limits.MaxPageSize - 1
+		}
+		v.intRange("offset", *p.Offset, -maxOffset, maxOffset)
+	}
+	if len(p.Mode) > 0 {
+		v.oneOf("mode", p.Mode, ActionsModes...)
+	}
+	return v.err()
+}
+
+func (p *GetTransactionParams) validate() error {
+	v := new(validator)
+	v.transactionId("id", p.Id)
+	return v.err()
+}
+
+func (p *GetKeyAccountsParams) validate() error {
+	v := new(validator)
+	v.publicKey("public_key", p.PublicKey)
+	v.name("permission", p.Permission, false)
+	v.intRange("limit", int64(p.Limit), 0, int64(MaxQuerySize))
+	if len(p.Cursor) > 0 && !isValidName(p.Cursor) {
+		v.fail("cursor", "invalid cursor")
+	}
+	return v.err()
+}
+
+func (p *GetControlledAccountsParams) validate() error {
+	v := new(validator)
+	v.name("controlling_account", p.ControllingAccount, true)
+	v.name("permission", p.Permission, false)
+	v.intRange("limit", int64(p.Limit), 0, int64(MaxQuerySize))
+	if len(p.Cursor) > 0 && !isValidName(p.Cursor) {
+		v.fail("cursor", "invalid cursor")
+	}
+	return v.err()
+}
+
+func (p *GetAccountParams) validate() error {
+	v := new(validator)
+	v.name("account_name", p.AccountName, true)
+	return v.err()
+}
+
+func (p *GetControlledAccountsGraphParams) validate() error {
+	v := new(validator)
+	v.name("controlling_account", p.ControllingAccount, true)
+	if p.Depth != nil {
+		v.intRange("depth", int64(*p.Depth), 1, int64(MaxGraphDepth))
+	}
+	if len(p.Format) > 0 {
+		v.oneOf("format", p.Format, GraphFormatJson, GraphFormatDot)
+	}
+	return v.err()
+}